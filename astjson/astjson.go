@@ -0,0 +1,243 @@
+// Package astjson converts lexemes and syntax trees to JSON and back. Node
+// kinds, operators and value types are written with the names of the Labels
+// maps of the lexer and parser, e.g. "ET_BINARY" or "LT_PLUS".
+package astjson
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+type Position struct {
+	Offset int `json:"offset"`
+	Row    int `json:"row"`
+	Column int `json:"column"`
+}
+
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Lexeme struct {
+	Type   string `json:"type"`
+	Label  string `json:"label"`
+	Value  string `json:"value,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+	Span   Span   `json:"span"`
+}
+
+type Expression struct {
+	Kind       string        `json:"kind"`
+	Operator   string        `json:"operator,omitempty"`
+	Identifier string        `json:"identifier,omitempty"`
+	Lhs        *Expression   `json:"lhs,omitempty"`
+	Rhs        *Expression   `json:"rhs,omitempty"`
+	Value      *Value        `json:"value,omitempty"`
+	Call       *FunctionCall `json:"call,omitempty"`
+	Span       Span          `json:"span"`
+}
+
+type Value struct {
+	Type     string    `json:"type"`
+	Literal  string    `json:"literal,omitempty"`
+	Integer  uint64    `json:"integer,omitempty"`
+	Float    float64   `json:"float,omitempty"`
+	Suffix   string    `json:"suffix,omitempty"`
+	Function *Function `json:"function,omitempty"`
+	Struct   *Struct   `json:"struct,omitempty"`
+}
+
+type Struct struct {
+	Name   string   `json:"name"`
+	Fields []*Field `json:"fields"`
+	Span   Span     `json:"span"`
+}
+
+// Field has a Type in struct declarations and a Value in struct literals
+type Field struct {
+	Name  string      `json:"name"`
+	Type  *Type       `json:"type,omitempty"`
+	Value *Expression `json:"value,omitempty"`
+	Span  Span        `json:"span"`
+}
+
+// Slices are written even when empty, so nil and empty survive a round trip
+type FunctionCall struct {
+	Name   string        `json:"name"`
+	Params []*Expression `json:"params"`
+}
+
+// Type is a type annotation, the types inferred by the type checker are not written
+type Type struct {
+	Kind   string  `json:"kind"`
+	Name   string  `json:"name,omitempty"`
+	Params []*Type `json:"params,omitempty"`
+	Result *Type   `json:"result,omitempty"`
+	Span   Span    `json:"span"`
+}
+
+type Param struct {
+	Name string `json:"name"`
+	Type *Type  `json:"type,omitempty"`
+	Span Span   `json:"span"`
+}
+
+type Function struct {
+	Name   string     `json:"name,omitempty"`
+	Props  []*Param   `json:"props"`
+	Result *Type      `json:"result,omitempty"`
+	Body   *Statement `json:"body,omitempty"`
+	Span   Span       `json:"span"`
+}
+
+type Declaration struct {
+	Name  string      `json:"name"`
+	Kind  string      `json:"kind"`
+	Type  *Type       `json:"type,omitempty"`
+	Value *Expression `json:"value,omitempty"`
+	Span  Span        `json:"span"`
+}
+
+type Assignment struct {
+	Target   *Expression `json:"target"`
+	Operator string      `json:"operator"`
+	Value    *Expression `json:"value"`
+}
+
+type If struct {
+	Condition  *Expression  `json:"condition,omitempty"`
+	Statements []*Statement `json:"statements"`
+	Else       *If          `json:"else,omitempty"`
+}
+
+type Loop struct {
+	Condition  *Expression  `json:"condition,omitempty"`
+	Variable   *Declaration `json:"variable,omitempty"`
+	Collection *Expression  `json:"collection,omitempty"`
+	Statements []*Statement `json:"statements"`
+}
+
+type Statement struct {
+	Kind        string       `json:"kind"`
+	Statements  []*Statement `json:"statements,omitempty"`
+	Statement   *Statement   `json:"statement,omitempty"`
+	Expression  *Expression  `json:"expression,omitempty"`
+	Function    *Function    `json:"function,omitempty"`
+	Declaration *Declaration `json:"declaration,omitempty"`
+	If          *If          `json:"if,omitempty"`
+	Assignment  *Assignment  `json:"assignment,omitempty"`
+	Loop        *Loop        `json:"loop,omitempty"`
+	Struct      *Struct      `json:"struct,omitempty"`
+	Span        Span         `json:"span"`
+}
+
+type Program struct {
+	Statements []*Statement `json:"statements"`
+}
+
+// EncodeLexemes returns the lexemes as an indented JSON array
+func EncodeLexemes(lexemes []lexer.Lexeme) ([]byte, error) {
+	encoded := make([]Lexeme, 0, len(lexemes))
+
+	for _, element := range lexemes {
+		encoded = append(encoded, Lexeme{
+			Type:   lexer.LexemeTypeLabels[element.Type],
+			Label:  element.Label,
+			Value:  element.Value,
+			Suffix: element.Suffix,
+			Span:   fromSpan(element.Span()),
+		})
+	}
+
+	return json.MarshalIndent(encoded, "", "  ")
+}
+
+// DecodeLexemes reads lexemes written by EncodeLexemes
+func DecodeLexemes(data []byte) ([]lexer.Lexeme, error) {
+	encoded := make([]Lexeme, 0)
+
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	lexemes := make([]lexer.Lexeme, 0, len(encoded))
+
+	for _, element := range encoded {
+		lexemeType, err := lookup(lexer.LexemeTypeLabels, element.Type, "lexeme type")
+
+		if err != nil {
+			return nil, err
+		}
+
+		span := toSpan(element.Span)
+
+		lexemes = append(lexemes, lexer.Lexeme{
+			Label:  element.Label,
+			Value:  element.Value,
+			Suffix: element.Suffix,
+			Type:   lexemeType,
+			Row:    span.Start.Row,
+			Column: span.Start.Column,
+			Offset: span.Start.Offset,
+			End:    span.End,
+		})
+	}
+
+	return lexemes, nil
+}
+
+// EncodeProgram returns the program as indented JSON
+func EncodeProgram(program *parser.AST_Program) ([]byte, error) {
+	encoded := Program{Statements: fromStatements(program.Statements)}
+
+	return json.MarshalIndent(encoded, "", "  ")
+}
+
+// DecodeProgram reconstructs a program written by EncodeProgram
+func DecodeProgram(data []byte) (*parser.AST_Program, error) {
+	encoded := Program{}
+
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	statements, err := toStatements(encoded.Statements)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser.AST_Program{Statements: statements}, nil
+}
+
+// lookup finds the constant whose label is name
+func lookup[T comparable](labels map[T]string, name string, what string) (T, error) {
+	for value, label := range labels {
+		if label == name {
+			return value, nil
+		}
+	}
+
+	var zero T
+
+	return zero, fmt.Errorf("unknown %s %q", what, name)
+}
+
+func fromSpan(span diagnostic.Span) Span {
+	return Span{
+		Start: Position(span.Start),
+		End:   Position(span.End),
+	}
+}
+
+func toSpan(span Span) diagnostic.Span {
+	return diagnostic.Span{
+		Start: diagnostic.Position(span.Start),
+		End:   diagnostic.Position(span.End),
+	}
+}
@@ -0,0 +1,112 @@
+package astjson
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+func TestProgramRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.cst")
+
+	if len(files) == 0 {
+		t.Error("astjson found no examples")
+		return
+	}
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Errorf("astjson could not read %s: %s", file, err)
+			continue
+		}
+
+		mainLexer := lexer.Create(string(contents))
+		mainLexer.Start()
+
+		mainParser := parser.Create(mainLexer)
+		program, _ := mainParser.Start()
+
+		data, err := EncodeProgram(program)
+
+		if err != nil {
+			t.Errorf("astjson.EncodeProgram failed for %s: %s", file, err)
+			continue
+		}
+
+		decoded, err := DecodeProgram(data)
+
+		if err != nil {
+			t.Errorf("astjson.DecodeProgram failed for %s: %s", file, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(program, decoded) {
+			t.Errorf("astjson round trip of %s does not match the parsed program", file)
+		}
+	}
+}
+
+func TestEncodeProgram(t *testing.T) {
+	mainLexer := lexer.Create("a + 1;")
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, _ := mainParser.Start()
+
+	data, err := EncodeProgram(program)
+
+	if err != nil {
+		t.Errorf("astjson.EncodeProgram failed: %s", err)
+		return
+	}
+
+	for _, expected := range []string{`"kind": "ET_BINARY"`, `"operator": "LT_PLUS"`, `"kind": "ET_IDENTIFIER"`, `"type": "TYPE_NUMBER"`, `"span"`} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("astjson.EncodeProgram output is missing %s", expected)
+		}
+	}
+}
+
+func TestLexemesRoundTrip(t *testing.T) {
+	mainLexer := lexer.Create("const s = \"a\\n\";\nx = 12u8;")
+	mainLexer.Start()
+
+	data, err := EncodeLexemes(mainLexer.Lexemes)
+
+	if err != nil {
+		t.Errorf("astjson.EncodeLexemes failed: %s", err)
+		return
+	}
+
+	decoded, err := DecodeLexemes(data)
+
+	if err != nil {
+		t.Errorf("astjson.DecodeLexemes failed: %s", err)
+		return
+	}
+
+	if !reflect.DeepEqual(mainLexer.Lexemes, decoded) {
+		t.Errorf("astjson lexeme round trip does not match.\nExpected %+v\ngot %+v", mainLexer.Lexemes, decoded)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	inputs := []string{
+		`{"statements": [{"kind": "ST_BOGUS"}]}`,
+		`{"statements": [{"kind": "ST_EXPRESSION", "expression": {"kind": "ET_BINARY", "operator": "LT_BOGUS"}}]}`,
+		`{"statements": `,
+	}
+
+	for _, input := range inputs {
+		if _, err := DecodeProgram([]byte(input)); err == nil {
+			t.Errorf("astjson.DecodeProgram accepted %s", input)
+		}
+	}
+}
@@ -0,0 +1,630 @@
+package astjson
+
+import (
+	"fmt"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+func fromStatements(statements []*parser.AST_Statement) []*Statement {
+	if statements == nil {
+		return nil
+	}
+
+	encoded := make([]*Statement, 0, len(statements))
+
+	for _, element := range statements {
+		encoded = append(encoded, fromStatement(element))
+	}
+
+	return encoded
+}
+
+func fromStatement(statement *parser.AST_Statement) *Statement {
+	if statement == nil {
+		return nil
+	}
+
+	encoded := &Statement{
+		Kind:        parser.StatementTypeLabels[statement.SType],
+		Statements:  fromStatements(statement.Statements),
+		Statement:   fromStatement(statement.Statement),
+		Expression:  fromExpression(statement.Expression),
+		Function:    fromFunction(statement.Function),
+		Declaration: fromDeclaration(statement.Declaration),
+		If:          fromIf(statement.If),
+		Struct:      fromStruct(statement.Struct),
+		Span:        fromSpan(statement.Span),
+	}
+
+	if statement.Assignment != nil {
+		encoded.Assignment = &Assignment{
+			Target:   fromExpression(statement.Assignment.Target),
+			Operator: lexer.LexemeTypeLabels[statement.Assignment.Operator],
+			Value:    fromExpression(statement.Assignment.Value),
+		}
+	}
+
+	if statement.Loop != nil {
+		encoded.Loop = &Loop{
+			Condition:  fromExpression(statement.Loop.Condition),
+			Variable:   fromDeclaration(statement.Loop.Variable),
+			Collection: fromExpression(statement.Loop.Collection),
+			Statements: fromStatements(statement.Loop.Statements),
+		}
+	}
+
+	return encoded
+}
+
+func fromDeclaration(declaration *parser.AST_Declaration) *Declaration {
+	if declaration == nil {
+		return nil
+	}
+
+	return &Declaration{
+		Name:  declaration.Name,
+		Kind:  parser.BindingKindLabels[declaration.Kind],
+		Type:  fromType(declaration.Type),
+		Value: fromExpression(declaration.Value),
+		Span:  fromSpan(declaration.Span),
+	}
+}
+
+func fromIf(branch *parser.AST_If) *If {
+	if branch == nil {
+		return nil
+	}
+
+	return &If{
+		Condition:  fromExpression(branch.Condition),
+		Statements: fromStatements(branch.Statements),
+		Else:       fromIf(branch.Else),
+	}
+}
+
+func fromExpressions(expressions []*parser.AST_Expression) []*Expression {
+	if expressions == nil {
+		return nil
+	}
+
+	encoded := make([]*Expression, 0, len(expressions))
+
+	for _, element := range expressions {
+		encoded = append(encoded, fromExpression(element))
+	}
+
+	return encoded
+}
+
+func fromExpression(expression *parser.AST_Expression) *Expression {
+	if expression == nil {
+		return nil
+	}
+
+	encoded := &Expression{
+		Kind:       parser.ExpressionTypeLabels[expression.EType],
+		Identifier: expression.Identifier,
+		Lhs:        fromExpression(expression.Lhs),
+		Rhs:        fromExpression(expression.Rhs),
+		Value:      fromValue(expression.Value),
+		Span:       fromSpan(expression.Span),
+	}
+
+	// The zero operator is LT_PLUS, it is only meaningful on operators
+	if expression.EType == parser.ET_BINARY || expression.EType == parser.ET_UNARY || expression.Operator != 0 {
+		encoded.Operator = lexer.LexemeTypeLabels[expression.Operator]
+	}
+
+	if expression.FunctionCall != nil {
+		encoded.Call = &FunctionCall{
+			Name:   expression.FunctionCall.Name,
+			Params: fromExpressions(expression.FunctionCall.Params),
+		}
+	}
+
+	return encoded
+}
+
+func fromValue(value *parser.AST_Value) *Value {
+	if value == nil {
+		return nil
+	}
+
+	encoded := &Value{
+		Type:     parser.LiteralTypeLabels[value.Type],
+		Literal:  value.Literal,
+		Integer:  value.Integer,
+		Float:    value.Float,
+		Suffix:   value.Suffix,
+		Function: fromFunction(value.Function),
+		Struct:   fromStruct(value.Struct),
+	}
+
+	return encoded
+}
+
+func fromStruct(structure *parser.AST_Struct) *Struct {
+	if structure == nil {
+		return nil
+	}
+
+	encoded := &Struct{
+		Name:   structure.Name,
+		Fields: make([]*Field, 0, len(structure.Fields)),
+		Span:   fromSpan(structure.Span),
+	}
+
+	for _, field := range structure.Fields {
+		encoded.Fields = append(encoded.Fields, &Field{
+			Name:  field.Name,
+			Type:  fromType(field.Type),
+			Value: fromExpression(field.Value),
+			Span:  fromSpan(field.Span),
+		})
+	}
+
+	return encoded
+}
+
+func fromFunction(function *parser.AST_Function) *Function {
+	if function == nil {
+		return nil
+	}
+
+	props := make([]*Param, 0, len(function.Props))
+
+	for _, param := range function.Props {
+		props = append(props, &Param{
+			Name: param.Name,
+			Type: fromType(param.Type),
+			Span: fromSpan(param.Span),
+		})
+	}
+
+	return &Function{
+		Name:   function.Name,
+		Props:  props,
+		Result: fromType(function.Result),
+		Body:   fromStatement(function.Statement),
+		Span:   fromSpan(function.Span),
+	}
+}
+
+func fromType(t *parser.AST_Type) *Type {
+	if t == nil {
+		return nil
+	}
+
+	encoded := &Type{
+		Kind:   parser.LiteralTypeLabels[t.Type],
+		Name:   t.Name,
+		Result: fromType(t.Result),
+		Span:   fromSpan(t.Span),
+	}
+
+	for _, param := range t.Params {
+		encoded.Params = append(encoded.Params, fromType(param))
+	}
+
+	return encoded
+}
+
+func toStatements(encoded []*Statement) ([]*parser.AST_Statement, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	statements := make([]*parser.AST_Statement, 0, len(encoded))
+
+	for _, element := range encoded {
+		statement, err := toStatement(element)
+
+		if err != nil {
+			return nil, err
+		}
+
+		statements = append(statements, statement)
+	}
+
+	return statements, nil
+}
+
+func toStatement(encoded *Statement) (*parser.AST_Statement, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	sType, err := lookup(parser.StatementTypeLabels, encoded.Kind, "statement kind")
+
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &parser.AST_Statement{
+		SType: sType,
+		Span:  toSpan(encoded.Span),
+	}
+
+	if statement.Statements, err = toStatements(encoded.Statements); err != nil {
+		return nil, err
+	}
+
+	// Empty blocks are omitted from the JSON but are never nil in the parser
+	if sType == parser.ST_STATEMENT_ARRAY && statement.Statements == nil {
+		statement.Statements = make([]*parser.AST_Statement, 0)
+	}
+
+	if statement.Statement, err = toStatement(encoded.Statement); err != nil {
+		return nil, err
+	}
+
+	if statement.Expression, err = toExpression(encoded.Expression); err != nil {
+		return nil, err
+	}
+
+	if statement.Function, err = toFunction(encoded.Function); err != nil {
+		return nil, err
+	}
+
+	if statement.Declaration, err = toDeclaration(encoded.Declaration); err != nil {
+		return nil, err
+	}
+
+	if statement.If, err = toIf(encoded.If); err != nil {
+		return nil, err
+	}
+
+	if encoded.Assignment != nil {
+		target, err := toExpression(encoded.Assignment.Target)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := toExpression(encoded.Assignment.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		operator, err := lookup(lexer.LexemeTypeLabels, encoded.Assignment.Operator, "operator")
+
+		if err != nil {
+			return nil, err
+		}
+
+		statement.Assignment = &parser.AST_Assignment{
+			Target:   target,
+			Operator: operator,
+			Value:    value,
+		}
+	}
+
+	if statement.Loop, err = toLoop(encoded.Loop); err != nil {
+		return nil, err
+	}
+
+	if statement.Struct, err = toStruct(encoded.Struct); err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
+
+func toDeclaration(encoded *Declaration) (*parser.AST_Declaration, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	value, err := toExpression(encoded.Value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	declared, err := toType(encoded.Type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	kind, err := lookup(parser.BindingKindLabels, encoded.Kind, "binding kind")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser.AST_Declaration{
+		Name:  encoded.Name,
+		Kind:  kind,
+		Type:  declared,
+		Value: value,
+		Span:  toSpan(encoded.Span),
+	}, nil
+}
+
+func toLoop(encoded *Loop) (*parser.AST_Loop, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	condition, err := toExpression(encoded.Condition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	variable, err := toDeclaration(encoded.Variable)
+
+	if err != nil {
+		return nil, err
+	}
+
+	collection, err := toExpression(encoded.Collection)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := toStatements(encoded.Statements)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser.AST_Loop{
+		Condition:  condition,
+		Variable:   variable,
+		Collection: collection,
+		Statements: statements,
+	}, nil
+}
+
+func toExpressions(encoded []*Expression) ([]*parser.AST_Expression, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	expressions := make([]*parser.AST_Expression, 0, len(encoded))
+
+	for _, element := range encoded {
+		expression, err := toExpression(element)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expressions = append(expressions, expression)
+	}
+
+	return expressions, nil
+}
+
+func toExpression(encoded *Expression) (*parser.AST_Expression, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	eType, err := lookup(parser.ExpressionTypeLabels, encoded.Kind, "expression kind")
+
+	if err != nil {
+		return nil, err
+	}
+
+	expression := &parser.AST_Expression{
+		EType:      eType,
+		Identifier: encoded.Identifier,
+		Span:       toSpan(encoded.Span),
+	}
+
+	if encoded.Operator != "" {
+		if expression.Operator, err = lookup(lexer.LexemeTypeLabels, encoded.Operator, "operator"); err != nil {
+			return nil, err
+		}
+	}
+
+	if expression.Lhs, err = toExpression(encoded.Lhs); err != nil {
+		return nil, err
+	}
+
+	if expression.Rhs, err = toExpression(encoded.Rhs); err != nil {
+		return nil, err
+	}
+
+	if expression.Value, err = toValue(encoded.Value); err != nil {
+		return nil, err
+	}
+
+	if encoded.Call != nil {
+		params, err := toExpressions(encoded.Call.Params)
+
+		if err != nil {
+			return nil, err
+		}
+
+		expression.FunctionCall = &parser.AST_FunctionCall{
+			Name:   encoded.Call.Name,
+			Params: params,
+		}
+	}
+
+	return expression, nil
+}
+
+func toValue(encoded *Value) (*parser.AST_Value, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	valueType, err := lookup(parser.LiteralTypeLabels, encoded.Type, "value type")
+
+	if err != nil {
+		return nil, err
+	}
+
+	function, err := toFunction(encoded.Function)
+
+	if err != nil {
+		return nil, err
+	}
+
+	structure, err := toStruct(encoded.Struct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value := &parser.AST_Value{
+		Literal:  encoded.Literal,
+		Function: function,
+		Struct:   structure,
+		Type:     valueType,
+		Integer:  encoded.Integer,
+		Float:    encoded.Float,
+		Suffix:   encoded.Suffix,
+	}
+
+	return value, nil
+}
+
+func toStruct(encoded *Struct) (*parser.AST_Struct, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	structure := &parser.AST_Struct{
+		Name:   encoded.Name,
+		Fields: make([]*parser.AST_Field, 0, len(encoded.Fields)),
+		Span:   toSpan(encoded.Span),
+	}
+
+	for _, field := range encoded.Fields {
+		declared, err := toType(field.Type)
+
+		if err != nil {
+			return nil, fmt.Errorf("struct %s: %w", encoded.Name, err)
+		}
+
+		value, err := toExpression(field.Value)
+
+		if err != nil {
+			return nil, fmt.Errorf("struct %s: %w", encoded.Name, err)
+		}
+
+		structure.Fields = append(structure.Fields, &parser.AST_Field{
+			Name:  field.Name,
+			Type:  declared,
+			Value: value,
+			Span:  toSpan(field.Span),
+		})
+	}
+
+	return structure, nil
+}
+
+func toIf(encoded *If) (*parser.AST_If, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	condition, err := toExpression(encoded.Condition)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statements, err := toStatements(encoded.Statements)
+
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := toIf(encoded.Else)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &parser.AST_If{
+		Condition:  condition,
+		Statements: statements,
+		Else:       next,
+	}, nil
+}
+
+func toFunction(encoded *Function) (*parser.AST_Function, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	body, err := toStatement(encoded.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", encoded.Name, err)
+	}
+
+	props := make([]*parser.AST_Param, 0, len(encoded.Props))
+
+	for _, param := range encoded.Props {
+		t, err := toType(param.Type)
+
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", encoded.Name, err)
+		}
+
+		props = append(props, &parser.AST_Param{Name: param.Name, Type: t, Span: toSpan(param.Span)})
+	}
+
+	result, err := toType(encoded.Result)
+
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", encoded.Name, err)
+	}
+
+	return &parser.AST_Function{
+		Name:      encoded.Name,
+		Props:     props,
+		Result:    result,
+		Statement: body,
+		Span:      toSpan(encoded.Span),
+	}, nil
+}
+
+func toType(encoded *Type) (*parser.AST_Type, error) {
+	if encoded == nil {
+		return nil, nil
+	}
+
+	kind, err := lookup(parser.LiteralTypeLabels, encoded.Kind, "type")
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := toType(encoded.Result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := &parser.AST_Type{Type: kind, Name: encoded.Name, Result: result, Span: toSpan(encoded.Span)}
+
+	if kind == parser.TYPE_FUNCTION {
+		// Function types always have a parameter list
+		t.Params = make([]*parser.AST_Type, 0, len(encoded.Params))
+	}
+
+	for _, param := range encoded.Params {
+		element, err := toType(param)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.Params = append(t.Params, element)
+	}
+
+	return t, nil
+}
@@ -2,14 +2,59 @@ package astprinter
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/milansav/Castle/lexer"
 	"github.com/milansav/Castle/parser"
 	"github.com/milansav/Castle/util"
 )
 
+var operatorNames = map[lexer.LexemeType]string{
+	lexer.LT_PLUS:     "ADD",
+	lexer.LT_MINUS:    "SUBTRACT",
+	lexer.LT_MULTIPLY: "MULTIPLY",
+	lexer.LT_DIVIDE:   "DIVIDE",
+	lexer.LT_MODULO:   "MODULO",
+	lexer.LT_POWER:    "POWER",
+	lexer.LT_EQ:       "EQUALS",
+	lexer.LT_NEQ:      "NOT EQUALS",
+	lexer.LT_GEQ:      "GREATER EQUAL",
+	lexer.LT_LEQ:      "LESS EQUAL",
+	lexer.LT_AND:      "AND",
+	lexer.LT_OR:       "OR",
+	lexer.LT_NAND:     "NAND",
+	lexer.LT_NOR:      "NOR",
+	lexer.LT_XAND:     "XAND",
+	lexer.LT_XOR:      "XOR",
+	lexer.LT_XNAND:    "XNAND",
+	lexer.LT_XNOR:     "XNOR",
+	lexer.LT_LCHEVRON: "LESS THAN",
+	lexer.LT_RCHEVRON: "GREATER THAN",
+
+	lexer.LT_EQUALS:          "ASSIGN",
+	lexer.LT_PLUS_EQUALS:     "ADD ASSIGN",
+	lexer.LT_MINUS_EQUALS:    "SUBTRACT ASSIGN",
+	lexer.LT_MULTIPLY_EQUALS: "MULTIPLY ASSIGN",
+	lexer.LT_DIVIDE_EQUALS:   "DIVIDE ASSIGN",
+	lexer.LT_MODULO_EQUALS:   "MODULO ASSIGN",
+}
+
 type ASTPrinter struct {
 	indentation int
+	writer      io.Writer
+	colors      bool
+}
+
+// color returns the escape sequence, or nothing when colors are disabled
+func (printer *ASTPrinter) color(sequence string) string {
+	if !printer.colors {
+		return ""
+	}
+
+	return sequence
 }
 
 func Prefix(printer *ASTPrinter) string {
@@ -25,18 +70,18 @@ func Prefix(printer *ASTPrinter) string {
 }
 
 func (printer *ASTPrinter) Group(name string) {
-	fmt.Printf("%s%s[ %s ]%s\n", Prefix(printer), util.Yellow, name, util.Reset)
+	fmt.Fprintf(printer.writer, "%s%s[ %s ]%s\n", Prefix(printer), printer.color(util.Yellow), name, printer.color(util.Reset))
 }
 
 func (printer *ASTPrinter) Value(name string, value string) {
 	printer.In()
-	fmt.Printf("%s- %s%s: %s%s\n", Prefix(printer), util.Yellow, name, util.Reset, value)
+	fmt.Fprintf(printer.writer, "%s- %s%s: %s%s\n", Prefix(printer), printer.color(util.Yellow), name, printer.color(util.Reset), value)
 	printer.Out()
 }
 
 func (printer *ASTPrinter) Info(description string) {
 	printer.In()
-	fmt.Printf("%s- %s%s%s\n", Prefix(printer), util.Yellow, description, util.Reset)
+	fmt.Fprintf(printer.writer, "%s- %s%s%s\n", Prefix(printer), printer.color(util.Yellow), description, printer.color(util.Reset))
 	printer.Out()
 
 }
@@ -49,208 +94,366 @@ func (printer *ASTPrinter) Out() {
 	printer.indentation--
 }
 
-func PrintAST(program *parser.AST_Program) {
-	printer := &ASTPrinter{
-		indentation: 0,
+// Create returns a printer writing to w, with colors when w is a terminal
+func Create(w io.Writer) *ASTPrinter {
+	return &ASTPrinter{
+		writer: w,
+		colors: util.ColorsEnabled(w),
 	}
+}
 
-	printer.Group("Program")
-	printer.In()
+func (printer *ASTPrinter) SetColors(colors bool) {
+	printer.colors = colors
+}
 
-	for _, value := range program.Statements {
-		printer.PrintStatement(value)
-	}
+// PrintAST writes the AST to stdout
+func PrintAST(program *parser.AST_Program) {
+	FprintAST(os.Stdout, program)
+}
 
-	printer.Out()
+// FprintAST writes the AST to w
+func FprintAST(w io.Writer, program *parser.AST_Program) {
+	Create(w).Print(program)
 }
 
-func (printer *ASTPrinter) PrintStatement(statement *parser.AST_Statement) {
-	// Group(printer, "Statement")
-	// Value(printer, "type", parser.StatementTypeLabels[statement.SType])
+// SprintAST returns the AST without colors
+func SprintAST(program *parser.AST_Program) string {
+	builder := strings.Builder{}
 
-	switch statement.SType {
-	case parser.ST_STATEMENT_ARRAY:
-		// Group(printer, "Block")
-		printer.In()
+	printer := Create(&builder)
+	printer.SetColors(false)
+	printer.Print(program)
 
-		for _, value := range statement.Statements {
-			printer.PrintStatement(value)
-		}
+	return builder.String()
+}
 
-		printer.Out()
-	case parser.ST_STATEMENT:
-		printer.Group("Statement")
-		printer.In()
+func (printer *ASTPrinter) Print(program *parser.AST_Program) {
+	parser.Walk(&walker{printer: printer}, program)
+}
 
-		printer.PrintStatement(statement.Statement)
+// PrintStatement writes the statement and the statements in it
+func (printer *ASTPrinter) PrintStatement(statement *parser.AST_Statement) {
+	parser.Walk(&walker{printer: printer}, statement)
+}
 
-		printer.Out()
-	case parser.ST_EXPRESSION:
-		// Group(printer, "Expression")
-		// printer.indentation++
-		printer.PrintExpression(statement.Expression)
-		// printer.indentation--
-	case parser.ST_FUNCTION:
-		printer.Group("Function")
+// PrintExpression writes the expression and its operands
+func (printer *ASTPrinter) PrintExpression(expression *parser.AST_Expression) {
+	parser.Walk(&walker{printer: printer}, expression)
+}
 
-		printer.In()
+// walker prints the tree with parser.Walk. Labels such as Condition, Body or
+// Value depend on where a node is, so it keeps the parents of the node.
+type walker struct {
+	printer *ASTPrinter
+	parents []parser.Node
+}
 
-		printer.PrintFunction(statement.Function)
+func (walker *walker) parent() parser.Node {
+	if len(walker.parents) == 0 {
+		return nil
+	}
 
-		printer.Out()
+	return walker.parents[len(walker.parents)-1]
+}
 
-	case parser.ST_DECLARATION:
-		printer.Group("Declaration")
-		printer.In()
-		PrintDeclaration(printer, statement.Declaration)
-		printer.Out()
-	case parser.ST_STRUCT:
-		printer.Group("Struct")
-	case parser.ST_IF:
-		printer.Group("If")
+func (walker *walker) Visit(node parser.Node) parser.Visitor {
+	if !walker.printer.enter(walker.parent(), node) {
+		walker.printer.unlabel(walker.parent(), node)
+		return nil
+	}
 
-		printer.Info("Condition")
+	walker.parents = append(walker.parents, node)
 
+	return walker
+}
+
+func (walker *walker) Leave(node parser.Node) {
+	walker.parents = walker.parents[:len(walker.parents)-1]
+	walker.printer.leave(walker.parent(), node)
+}
+
+// enter prints the node before its children, or all of it when it returns
+// false, then the children are skipped and only unlabel is called
+func (printer *ASTPrinter) enter(parent parser.Node, node parser.Node) bool {
+	printer.label(parent, node)
+
+	switch n := node.(type) {
+	case *parser.AST_Program:
+		printer.Group("Program")
+		printer.In()
+	case *parser.AST_Statement:
+		return printer.enterStatement(n)
+	case *parser.AST_Expression:
+		return printer.enterExpression(n)
+	case *parser.AST_Function:
+		printer.Value("Name", n.Name)
+		printer.Info("Args")
 		printer.In()
 
-		printer.PrintExpression(statement.If.Condition)
+		for _, value := range n.Props {
+			printer.Info(value.String())
+		}
 
 		printer.Out()
 
-		printer.Info("Body")
+		if n.Result != nil {
+			printer.Value("Result", n.Result.String())
+		}
 
+		printer.Info("Body")
 		printer.In()
+	case *parser.AST_Declaration:
+		if _, ok := parent.(*parser.AST_Loop); ok {
+			// The variable of a for loop, printed by the loop
+			return false
+		}
+
+		printer.Value("Name", n.Name)
+		printer.Value("Kind", parser.BindingKindLabels[n.Kind])
 
-		for _, value := range statement.If.Statements {
-			printer.PrintStatement(value)
+		if n.Type != nil {
+			printer.Value("Type", n.Type.String())
 		}
+	case *parser.AST_If:
+		if _, ok := parent.(*parser.AST_If); ok {
+			// Close the body of the previous branch
+			printer.Out()
+			printer.In()
 
-		printer.Out()
-	case parser.ST_RETURN:
-		printer.Group("Return")
-		printer.Info("Value")
+			if n.Condition != nil {
+				printer.Group("ElseIf")
+			} else {
+				printer.Group("Else")
+			}
+		}
+
+		if n.Condition == nil {
+			printer.Info("Body")
+			printer.In()
+		}
+	case *parser.AST_Loop:
+		if n.Variable != nil {
+			printer.Value("Variable", n.Variable.Name)
+		}
+	case *parser.AST_Value:
+		// Function literals are printed without their body
+		return n.Function == nil
+	case *parser.AST_Field:
+		printer.Info(n.Name)
 		printer.In()
-		printer.PrintExpression(statement.Expression)
-		printer.Out()
 	}
+
+	return true
 }
 
-func (printer *ASTPrinter) PrintFunction(function *parser.AST_Function) {
-	printer.Value("Name", function.Name)
-	printer.Info("Args")
-	printer.In()
+// label prints the label of a child, e.g. the Condition of an if
+func (printer *ASTPrinter) label(parent parser.Node, node parser.Node) {
+	expression, ok := node.(*parser.AST_Expression)
 
-	for _, value := range function.Props {
-		printer.Info(value)
+	if !ok {
+		return
 	}
 
-	printer.Out()
-
-	printer.Info("Body")
+	switch p := parent.(type) {
+	case *parser.AST_Statement:
+		if p.SType == parser.ST_RETURN {
+			printer.Info("Value")
+			printer.In()
+		}
+	case *parser.AST_Declaration:
+		printer.Info("Value")
+		printer.In()
+	case *parser.AST_Assignment:
+		if expression == p.Target {
+			printer.Info("Target")
+		} else {
+			printer.Info("Value")
+		}
 
-	printer.In()
+		printer.In()
+	case *parser.AST_If:
+		printer.Info("Condition")
+		printer.In()
+	case *parser.AST_Loop:
+		if expression == p.Condition {
+			printer.Info("Condition")
+		} else {
+			printer.Info("Collection")
+		}
 
-	printer.PrintStatement(function.Statement)
+		printer.In()
+	case *parser.AST_FunctionCall:
+		for index, param := range p.Params {
+			if param == expression {
+				printer.Info(fmt.Sprintf("%d", index))
+			}
+		}
 
-	printer.Out()
+		printer.In()
+	}
 }
 
-func PrintDeclaration(printer *ASTPrinter, declaration *parser.AST_Declaration) {
-	printer.Value("Name", declaration.Name)
-	printer.Info("Value")
+func (printer *ASTPrinter) enterStatement(statement *parser.AST_Statement) bool {
+	switch statement.SType {
+	case parser.ST_STATEMENT_ARRAY:
+		printer.In()
+	case parser.ST_STATEMENT:
+		printer.Group("Statement")
+		printer.In()
+	case parser.ST_EXPRESSION:
+	case parser.ST_FUNCTION:
+		printer.Group("Function")
+		printer.In()
+	case parser.ST_DECLARATION:
+		printer.Group("Declaration")
+		printer.In()
+	case parser.ST_ASSIGNMENT:
+		printer.Group("Assignment")
+		printer.Value("Operator", operatorNames[statement.Assignment.Operator])
+	case parser.ST_STRUCT, parser.ST_INTERFACE:
+		if statement.SType == parser.ST_STRUCT {
+			printer.Group("Struct")
+		} else {
+			printer.Group("Interface")
+		}
 
-	printer.In()
-	printer.PrintExpression(declaration.Value)
-	printer.Out()
+		printer.Value("Name", statement.Struct.Name)
+		printer.Info("Fields")
+		printer.In()
 
-}
+		for _, field := range statement.Struct.Fields {
+			printer.Info(field.String())
+		}
 
-func (printer *ASTPrinter) PrintExpression(expression *parser.AST_Expression) {
-	// Group(printer, "Expression")
+		printer.Out()
+
+		return false
+	case parser.ST_IF:
+		printer.Group("If")
+	case parser.ST_WHILE:
+		printer.Group("While")
+	case parser.ST_FOR:
+		printer.Group("For")
+	case parser.ST_RETURN:
+		printer.Group("Return")
+	case parser.ST_BREAK:
+		printer.Group("Break")
+	case parser.ST_CONTINUE:
+		printer.Group("Continue")
+	case parser.ST_ERROR:
+		printer.Group("Error")
+	default:
+		return false
+	}
+
+	return true
+}
 
+func (printer *ASTPrinter) enterExpression(expression *parser.AST_Expression) bool {
 	switch expression.EType {
 	case parser.ET_GROUP:
 		printer.Group("Group")
 		printer.In()
-		printer.PrintExpression(expression.Lhs)
-		printer.Out()
 	case parser.ET_BINARY:
-		switch expression.Operator {
-		case lexer.LT_PLUS:
-			printer.Group("ADD")
-		case lexer.LT_MINUS:
-			printer.Group("SUBTRACT")
-		case lexer.LT_MULTIPLY:
-			printer.Group("MULTIPLY")
-		case lexer.LT_DIVIDE:
-			printer.Group("DIVIDE")
-		case lexer.LT_MODULO:
-			printer.Group("MODULO")
-		case lexer.LT_POWER:
-			printer.Group("POWER")
-		case lexer.LT_EQ:
-			printer.Group("EQUALS")
-		case lexer.LT_NEQ:
-			printer.Group("NOT EQUALS")
-		case lexer.LT_GEQ:
-			printer.Group("GREATER EQUAL")
-		case lexer.LT_LEQ:
-			printer.Group("LESS EQUAL")
-		case lexer.LT_AND:
-			printer.Group("AND")
-		case lexer.LT_OR:
-			printer.Group("OR")
-		case lexer.LT_NAND:
-			printer.Group("NAND")
-		case lexer.LT_NOR:
-			printer.Group("NOR")
-		case lexer.LT_XAND:
-			printer.Group("XAND")
-		case lexer.LT_XOR:
-			printer.Group("XOR")
-		case lexer.LT_XNAND:
-			printer.Group("XNAND")
-		case lexer.LT_XNOR:
-			printer.Group("XNOR")
-		case lexer.LT_LCHEVRON:
-			printer.Group("LESS THAN")
-		case lexer.LT_RCHEVRON:
-			printer.Group("GREATER THAN")
-		default:
+		if name, ok := operatorNames[expression.Operator]; ok {
+			printer.Group(name)
+		} else {
 			printer.Group("UNKNOWN")
 		}
-		printer.In()
-		printer.PrintExpression(expression.Lhs)
-		printer.Out()
 
 		printer.In()
-		printer.PrintExpression(expression.Rhs)
-		printer.Out()
 	case parser.ET_VALUE:
 		printer.Group("Literal")
-		printer.Value("Value", expression.Value.Literal)
+
+		if expression.Value.Type == parser.TYPE_STRING {
+			printer.Value("Value", strconv.Quote(expression.Value.Literal))
+		} else {
+			printer.Value("Value", expression.Value.Literal)
+		}
+
 		printer.Value("Type", parser.LiteralTypeLabels[expression.Value.Type])
+
+		if expression.Value.Suffix != "" {
+			printer.Value("Suffix", expression.Value.Suffix)
+		}
+
+		if expression.Value.Struct != nil {
+			printer.Info("Fields")
+			printer.In()
+		}
 	case parser.ET_IDENTIFIER:
 		printer.Group("Identifier")
 		printer.Value("Name", expression.Identifier)
+
+		return false
 	case parser.ET_FUNCTION_CALL:
 		printer.Group("Call")
 		printer.Value("Name", expression.FunctionCall.Name)
-
 		printer.Info("Args")
 		printer.In()
-		for index, value := range expression.FunctionCall.Params {
-			printer.Info(fmt.Sprintf("%d", index))
-			printer.In()
-			printer.PrintExpression(value)
-			printer.Out()
-		}
-		printer.Out()
 	case parser.ET_EXPRESSION_ARRAY:
 		printer.Group("Expressions")
 		printer.In()
-		printer.PrintExpression(expression.Lhs)
-		printer.PrintExpression(expression.Rhs)
+	case parser.ET_ERROR:
+		printer.Group("Error")
+	default:
+		return false
+	}
+
+	return true
+}
+
+// leave undoes the indentation of enter and label after the children of the node
+func (printer *ASTPrinter) leave(parent parser.Node, node parser.Node) {
+	switch n := node.(type) {
+	case *parser.AST_Program, *parser.AST_Function, *parser.AST_Field:
+		printer.Out()
+	case *parser.AST_Statement:
+		switch n.SType {
+		case parser.ST_STATEMENT_ARRAY, parser.ST_STATEMENT, parser.ST_FUNCTION, parser.ST_DECLARATION:
+			printer.Out()
+		}
+	case *parser.AST_Expression:
+		switch n.EType {
+		case parser.ET_GROUP, parser.ET_BINARY, parser.ET_FUNCTION_CALL, parser.ET_EXPRESSION_ARRAY:
+			printer.Out()
+		case parser.ET_VALUE:
+			if n.Value.Struct != nil {
+				printer.Out()
+			}
+		}
+	case *parser.AST_If:
+		if n.Else == nil {
+			printer.Out()
+		}
+
+		if _, ok := parent.(*parser.AST_If); ok {
+			printer.Out()
+		}
+	case *parser.AST_Loop:
+		printer.Out()
+	}
+
+	printer.unlabel(parent, node)
+}
+
+// unlabel undoes the indentation of label, the condition of a branch or a
+// loop is followed by its body
+func (printer *ASTPrinter) unlabel(parent parser.Node, node parser.Node) {
+	if _, ok := node.(*parser.AST_Expression); !ok {
+		return
+	}
+
+	switch p := parent.(type) {
+	case *parser.AST_Statement:
+		if p.SType == parser.ST_RETURN {
+			printer.Out()
+		}
+	case *parser.AST_Declaration, *parser.AST_Assignment, *parser.AST_FunctionCall:
+		printer.Out()
+	case *parser.AST_If, *parser.AST_Loop:
 		printer.Out()
+		printer.Info("Body")
+		printer.In()
 	}
 }
@@ -0,0 +1,105 @@
+package astprinter
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func parse(input string) *parser.AST_Program {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, _ := mainParser.Start()
+
+	return program
+}
+
+// TestGolden compares the AST of every example with testdata/<example>.ast,
+// run go test ./astprinter -update after an intended change of the output
+func TestGolden(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.cst")
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Errorf("astprinter could not read %s: %s", file, err)
+			continue
+		}
+
+		output := SprintAST(parse(string(contents)))
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(file), ".cst")+".ast")
+
+		if *update {
+			if err := os.WriteFile(golden, []byte(output), 0644); err != nil {
+				t.Errorf("astprinter could not write %s: %s", golden, err)
+			}
+
+			continue
+		}
+
+		expected, err := os.ReadFile(golden)
+
+		if err != nil {
+			t.Errorf("astprinter could not read %s: %s", golden, err)
+			continue
+		}
+
+		if output != string(expected) {
+			t.Errorf("astprinter output for %s does not match %s.\nExpected\n%s\ngot\n%s", file, golden, expected, output)
+		}
+	}
+}
+
+func TestColors(t *testing.T) {
+	program := parse("1;")
+
+	if output := SprintAST(program); strings.Contains(output, "\033[") {
+		t.Errorf("astprinter.SprintAST output contains colors %q", output)
+	}
+
+	builder := strings.Builder{}
+
+	printer := Create(&builder)
+	printer.SetColors(true)
+	printer.Print(program)
+
+	if !strings.Contains(builder.String(), "\033[") {
+		t.Errorf("astprinter with colors has no escape sequences %q", builder.String())
+	}
+
+	t.Setenv("NO_COLOR", "1")
+
+	if printer := Create(os.Stdout); printer.colors {
+		t.Error("astprinter.Create enabled colors although NO_COLOR is set")
+	}
+}
+
+func TestDOT(t *testing.T) {
+	output := SprintDOT(parse("const s = f(\"a\\\"b\", -x);"))
+
+	expected := []string{
+		"digraph AST {",
+		`n1 [label="ST_DECLARATION\ns"];`,
+		`n2 [label="ET_FUNCTION_CALL\nf"];`,
+		`n3 [label="ET_LITERAL\nTYPE_STRING\n\"a\\\"b\""];`,
+		`n2 -> n3 [label="Params[0]"];`,
+		`n4 [label="ET_UNARY\nNEGATE"];`,
+		`n1 -> n2 [label="Value"];`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Errorf("astprinter.SprintDOT output is missing %s\n%s", line, output)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package astprinter
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+// DOTPrinter renders the AST as a Graphviz graph, render it with
+// dot -Tsvg file.dot > file.svg
+type DOTPrinter struct {
+	writer io.Writer
+	nodes  int
+}
+
+// FprintDOT writes the AST to w as a DOT graph
+func FprintDOT(w io.Writer, program *parser.AST_Program) {
+	printer := &DOTPrinter{writer: w}
+
+	fmt.Fprintln(w, "digraph AST {")
+	fmt.Fprintln(w, "  node [shape=box, fontname=\"monospace\"];")
+
+	parser.Walk(&dotWalker{printer: printer}, program)
+
+	fmt.Fprintln(w, "}")
+}
+
+// SprintDOT returns the AST as a DOT graph
+func SprintDOT(program *parser.AST_Program) string {
+	builder := strings.Builder{}
+	FprintDOT(&builder, program)
+
+	return builder.String()
+}
+
+// node writes a node with one label line per element and returns its id
+func (printer *DOTPrinter) node(lines ...string) string {
+	id := fmt.Sprintf("n%d", printer.nodes)
+	printer.nodes++
+
+	escaped := make([]string, 0, len(lines))
+
+	for _, line := range lines {
+		escaped = append(escaped, escapeDOT(line))
+	}
+
+	fmt.Fprintf(printer.writer, "  %s [label=\"%s\"];\n", id, strings.Join(escaped, "\\n"))
+
+	return id
+}
+
+func (printer *DOTPrinter) edge(from string, to string, label string) {
+	fmt.Fprintf(printer.writer, "  %s -> %s [label=\"%s\"];\n", from, to, escapeDOT(label))
+}
+
+func escapeDOT(text string) string {
+	replacer := strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+	return replacer.Replace(text)
+}
+
+// dotWalker draws the tree with parser.Walk. Nodes such as declarations or
+// loops are not drawn, their children hang off the statement holding them.
+type dotWalker struct {
+	printer *DOTPrinter
+	frames  []dotFrame
+}
+
+type dotFrame struct {
+	node parser.Node
+	// The drawn node, or the one of the nearest drawn parent
+	id    string
+	drawn bool
+}
+
+func (walker *dotWalker) parent() dotFrame {
+	if len(walker.frames) == 0 {
+		return dotFrame{}
+	}
+
+	return walker.frames[len(walker.frames)-1]
+}
+
+func (walker *dotWalker) Visit(node parser.Node) parser.Visitor {
+	parent := walker.parent()
+
+	if _, ok := node.(*parser.AST_Declaration); ok {
+		if _, ok := parent.node.(*parser.AST_Loop); ok {
+			// The variable of a for loop, part of the loop node
+			return nil
+		}
+	}
+
+	frame := dotFrame{node: node}
+	frame.id, frame.drawn = walker.printer.draw(parent.node, node)
+
+	if !frame.drawn {
+		frame.id = parent.id
+	}
+
+	// Struct declarations list their fields in their node
+	if statement, ok := node.(*parser.AST_Statement); ok && (statement.SType == parser.ST_STRUCT || statement.SType == parser.ST_INTERFACE) {
+		walker.printer.edge(parent.id, frame.id, edgeLabel(parent.node, node))
+		return nil
+	}
+
+	walker.frames = append(walker.frames, frame)
+
+	return walker
+}
+
+// Leave writes the edge to the node after its children, like the nodes the ids are numbered in pre-order
+func (walker *dotWalker) Leave(node parser.Node) {
+	frame := walker.frames[len(walker.frames)-1]
+	walker.frames = walker.frames[:len(walker.frames)-1]
+
+	if parent := walker.parent(); frame.drawn && parent.node != nil {
+		walker.printer.edge(parent.id, frame.id, edgeLabel(parent.node, node))
+	}
+}
+
+// draw writes the node and returns its id, false for nodes that are not drawn
+func (printer *DOTPrinter) draw(parent parser.Node, node parser.Node) (string, bool) {
+	switch n := node.(type) {
+	case *parser.AST_Program:
+		return printer.node("Program"), true
+	case *parser.AST_Statement:
+		return printer.statement(n), true
+	case *parser.AST_Expression:
+		return printer.expression(n), true
+	case *parser.AST_Function:
+		return printer.function(n), true
+	case *parser.AST_If:
+		if _, ok := parent.(*parser.AST_If); !ok {
+			// The first branch is the if statement
+			return "", false
+		}
+
+		if n.Condition == nil {
+			return printer.node("Else"), true
+		}
+
+		return printer.node("ElseIf"), true
+	}
+
+	return "", false
+}
+
+// edgeLabel returns the label of the edge from the parent to the node, e.g. Condition
+func edgeLabel(parent parser.Node, node parser.Node) string {
+	switch p := parent.(type) {
+	case *parser.AST_Program:
+		return statementsLabel(p.Statements, node)
+	case *parser.AST_Statement:
+		switch p.SType {
+		case parser.ST_STATEMENT_ARRAY:
+			return statementsLabel(p.Statements, node)
+		case parser.ST_STATEMENT:
+			return "Statement"
+		case parser.ST_FUNCTION:
+			return "Function"
+		}
+
+		return "Expression"
+	case *parser.AST_Declaration:
+		return "Value"
+	case *parser.AST_Assignment:
+		if node == parser.Node(p.Target) {
+			return "Target"
+		}
+
+		return "Value"
+	case *parser.AST_If:
+		if node == parser.Node(p.Condition) {
+			return "Condition"
+		} else if node == parser.Node(p.Else) {
+			return "Else"
+		}
+
+		return statementsLabel(p.Statements, node)
+	case *parser.AST_Loop:
+		if node == parser.Node(p.Condition) {
+			return "Condition"
+		} else if node == parser.Node(p.Collection) {
+			return "Collection"
+		}
+
+		return statementsLabel(p.Statements, node)
+	case *parser.AST_Function:
+		return "Body"
+	case *parser.AST_Value:
+		return "Function"
+	case *parser.AST_Field:
+		return p.Name
+	case *parser.AST_FunctionCall:
+		for index, param := range p.Params {
+			if node == parser.Node(param) {
+				return fmt.Sprintf("Params[%d]", index)
+			}
+		}
+	case *parser.AST_Expression:
+		if node == parser.Node(p.Lhs) {
+			return "Lhs"
+		}
+
+		return "Rhs"
+	}
+
+	return ""
+}
+
+func statementsLabel(statements []*parser.AST_Statement, node parser.Node) string {
+	for index, statement := range statements {
+		if node == parser.Node(statement) {
+			return fmt.Sprintf("Statements[%d]", index)
+		}
+	}
+
+	return ""
+}
+
+// statement writes the node of a statement, the name of a declaration or the
+// fields of a struct are part of it
+func (printer *DOTPrinter) statement(statement *parser.AST_Statement) string {
+	kind := parser.StatementTypeLabels[statement.SType]
+
+	switch statement.SType {
+	case parser.ST_DECLARATION:
+		name := statement.Declaration.Name
+
+		if statement.Declaration.Type != nil {
+			name += ": " + statement.Declaration.Type.String()
+		}
+
+		return printer.node(kind, name)
+	case parser.ST_ASSIGNMENT:
+		return printer.node(kind, operatorNames[statement.Assignment.Operator])
+	case parser.ST_STRUCT, parser.ST_INTERFACE:
+		fields := make([]string, 0, len(statement.Struct.Fields))
+
+		for _, field := range statement.Struct.Fields {
+			fields = append(fields, field.String())
+		}
+
+		return printer.node(append([]string{kind, statement.Struct.Name}, fields...)...)
+	case parser.ST_FOR:
+		return printer.node(kind, statement.Loop.Variable.Name)
+	default:
+		return printer.node(kind)
+	}
+}
+
+// function writes the node of a function with its signature
+func (printer *DOTPrinter) function(function *parser.AST_Function) string {
+	props := make([]string, 0, len(function.Props))
+
+	for _, param := range function.Props {
+		props = append(props, param.String())
+	}
+
+	signature := fmt.Sprintf("%s(%s)", function.Name, strings.Join(props, ", "))
+
+	if function.Result != nil {
+		signature += ": " + function.Result.String()
+	}
+
+	return printer.node("Function", signature)
+}
+
+// expression writes the node of an expression, operators and literals are part of it
+func (printer *DOTPrinter) expression(expression *parser.AST_Expression) string {
+	kind := parser.ExpressionTypeLabels[expression.EType]
+
+	switch expression.EType {
+	case parser.ET_BINARY:
+		return printer.node(kind, operatorNames[expression.Operator])
+	case parser.ET_UNARY:
+		operator := "NEGATE"
+
+		if expression.Operator == lexer.LT_BANG {
+			operator = "NOT"
+		}
+
+		return printer.node(kind, operator)
+	case parser.ET_VALUE:
+		value := expression.Value
+
+		if value.Type == parser.TYPE_FUNCTION {
+			return printer.node(kind, parser.LiteralTypeLabels[value.Type])
+		}
+
+		if value.Struct != nil {
+			return printer.node(kind, value.Struct.Name)
+		}
+
+		literal := value.Literal
+
+		if value.Type == parser.TYPE_STRING {
+			literal = strconv.Quote(literal)
+		}
+
+		return printer.node(kind, parser.LiteralTypeLabels[value.Type], literal+value.Suffix)
+	case parser.ET_IDENTIFIER, parser.ET_MEMBER_ACCESS:
+		return printer.node(kind, expression.Identifier)
+	case parser.ET_FUNCTION_CALL:
+		return printer.node(kind, expression.FunctionCall.Name)
+	default:
+		return printer.node(kind)
+	}
+}
@@ -0,0 +1,133 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/milansav/Castle/diagnostic"
+)
+
+// Compiler returns the C compiler to use, taken from the CC environment variable
+func Compiler() string {
+	if compiler := os.Getenv("CC"); compiler != "" {
+		return compiler
+	}
+
+	return "cc"
+}
+
+// Matches "file:row:column: severity: message", as printed by gcc and clang
+var compilerMessage = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+
+// Compile compiles the C file into an executable. Messages of the C compiler
+// about the Castle source (through #line directives) or the generated C are
+// returned as diagnostics, err is only set when the compiler could not be run.
+func Compile(source string, cFile string, output string) ([]diagnostic.Diagnostic, error) {
+	compiler := Compiler()
+
+	// CC may contain flags, e.g. CC="gcc -O2"
+	arguments := strings.Fields(compiler)
+
+	if len(arguments) == 0 {
+		arguments = []string{"cc"}
+	}
+
+	arguments = append(arguments, cFile, "-o", output)
+
+	command := exec.Command(arguments[0], arguments[1:]...)
+
+	stderr := bytes.Buffer{}
+	command.Stdout = &stderr
+	command.Stderr = &stderr
+
+	runErr := command.Run()
+
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return nil, fmt.Errorf("could not run C compiler %s: %w", arguments[0], runErr)
+	}
+
+	diagnostics := parseCompilerOutput(source, cFile, stderr.String())
+
+	if runErr != nil && !diagnostic.HasErrors(diagnostics) {
+		failure := diagnostic.Error(
+			diagnostic.CODE_C_COMPILER,
+			diagnostic.Span{},
+			"C compiler %s failed: %s", arguments[0], runErr)
+
+		for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+			failure = failure.WithNote("%s", line)
+		}
+
+		diagnostics = append(diagnostics, failure)
+	}
+
+	return diagnostics, nil
+}
+
+func parseCompilerOutput(source string, cFile string, output string) []diagnostic.Diagnostic {
+	diagnostics := make([]diagnostic.Diagnostic, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		// Source excerpts and other context lines are skipped
+		match := compilerMessage.FindStringSubmatch(line)
+
+		if match == nil {
+			continue
+		}
+
+		row, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+
+		if match[4] == "note" {
+			if len(diagnostics) > 0 {
+				last := &diagnostics[len(diagnostics)-1]
+				*last = last.WithNote("%s:%d:%d: %s", match[1], row, column, match[5])
+			}
+
+			continue
+		}
+
+		current := diagnostic.Diagnostic{
+			Severity: diagnostic.SV_ERROR,
+			Code:     diagnostic.CODE_C_COMPILER,
+			Message:  match[5],
+		}
+
+		if match[4] == "warning" {
+			current.Severity = diagnostic.SV_WARNING
+		}
+
+		if sameFile(match[1], source) {
+			position := diagnostic.Position{Row: row, Column: column}
+			current.Span = diagnostic.Span{Start: position, End: position}
+		} else {
+			// The message is about generated code which has no Castle counterpart
+			current = current.WithNote("in generated C at %s:%d:%d", match[1], row, column)
+
+			if !sameFile(match[1], cFile) {
+				current.Message = fmt.Sprintf("%s (in %s)", current.Message, match[1])
+			}
+		}
+
+		diagnostics = append(diagnostics, current)
+	}
+
+	return diagnostics
+}
+
+func sameFile(a string, b string) bool {
+	absoluteA, errA := filepath.Abs(a)
+	absoluteB, errB := filepath.Abs(b)
+
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return absoluteA == absoluteB
+}
@@ -0,0 +1,36 @@
+package build
+
+import (
+	"testing"
+
+	"github.com/milansav/Castle/diagnostic"
+)
+
+func TestParseCompilerOutput(t *testing.T) {
+	output := `main.cst: In function 'f':
+main.cst:2:12: error: 'x' undeclared (first use in this function)
+    2 |     return x + n;
+      |            ^
+main.cst:2:12: note: each undeclared identifier is reported only once
+/tmp/castle-1/main.c:7:5: warning: unused variable 'y'
+`
+
+	diagnostics := parseCompilerOutput("main.cst", "/tmp/castle-1/main.c", output)
+
+	if len(diagnostics) != 2 {
+		t.Errorf("parseCompilerOutput returned %d diagnostics, expected 2", len(diagnostics))
+		return
+	}
+
+	first := diagnostics[0]
+
+	if first.Severity != diagnostic.SV_ERROR || first.Span.Start.String() != "2:12" || len(first.Notes) != 1 {
+		t.Errorf("parseCompilerOutput error is incorrect: %s", first.Format("main.cst"))
+	}
+
+	second := diagnostics[1]
+
+	if second.Severity != diagnostic.SV_WARNING || second.Span.Start.Row != 0 || len(second.Notes) != 1 {
+		t.Errorf("parseCompilerOutput warning in generated code is incorrect: %s", second.Format("main.cst"))
+	}
+}
@@ -1,49 +1,340 @@
 package cli
 
 import (
-	"github.com/milansav/Castle/util"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 )
 
+// Version is overridden at build time with -ldflags "-X github.com/milansav/Castle/cli.Version=..."
+var Version = "0.1.0-dev"
+
+type Mode int
+
+const (
+	MODE_LEX   Mode = iota // Print the lexemes
+	MODE_PARSE             // Print the AST
+	MODE_CHECK             // Only report diagnostics
+	MODE_BUILD             // Compile the generated C into an executable
+	MODE_RUN               // Build and execute
+	MODE_FMT               // Format the source
+)
+
+var ModeLabels = map[Mode]string{
+	MODE_LEX:   "lex",
+	MODE_PARSE: "parse",
+	MODE_CHECK: "check",
+	MODE_BUILD: "build",
+	MODE_RUN:   "run",
+	MODE_FMT:   "fmt",
+}
+
+var modeDescriptions = map[Mode]string{
+	MODE_LEX:   "Print the lexemes of the files",
+	MODE_PARSE: "Print the abstract syntax tree of the files",
+	MODE_CHECK: "Report errors in the files without generating code",
+	MODE_BUILD: "Compile the files into executables with the system C compiler",
+	MODE_RUN:   "Build a file and run it, arguments after -- are passed to the program",
+	MODE_FMT:   "Print the files formatted, or check or rewrite them",
+}
+
+type Stage int
+
+// Stages are ordered by how far the compiler has to get to produce them
+const (
+	STAGE_TOKENS      Stage = iota // Lexemes
+	STAGE_TOKENS_JSON              // Lexemes as JSON
+	STAGE_AST                      // Abstract syntax tree
+	STAGE_AST_JSON                 // Abstract syntax tree as JSON
+	STAGE_AST_DOT                  // Abstract syntax tree as a Graphviz graph
+	STAGE_IR                       // Intermediate representation
+	STAGE_C                        // Generated C
+)
+
+var StageLabels = map[Stage]string{
+	STAGE_TOKENS:      "tokens",
+	STAGE_TOKENS_JSON: "tokens-json",
+	STAGE_AST:         "ast",
+	STAGE_AST_JSON:    "ast-json",
+	STAGE_AST_DOT:     "ast-dot",
+	STAGE_IR:          "ir",
+	STAGE_C:           "c",
+}
+
+// StageExtensions are the extensions of the files written with --outdir
+var StageExtensions = map[Stage]string{
+	STAGE_TOKENS:      ".tokens",
+	STAGE_TOKENS_JSON: ".tokens.json",
+	STAGE_AST:         ".ast",
+	STAGE_AST_JSON:    ".ast.json",
+	STAGE_AST_DOT:     ".dot",
+	STAGE_IR:          ".ir",
+	STAGE_C:           ".c",
+}
+
+var stageOrder = []Stage{STAGE_TOKENS, STAGE_TOKENS_JSON, STAGE_AST, STAGE_AST_JSON, STAGE_AST_DOT, STAGE_IR, STAGE_C}
+
+// stageList is a repeatable flag, each value may list several stages separated by commas
+type stageList struct {
+	stages *[]Stage
+}
+
+func (list stageList) String() string {
+	if list.stages == nil {
+		return ""
+	}
+
+	labels := make([]string, 0)
+
+	for _, stage := range *list.stages {
+		labels = append(labels, StageLabels[stage])
+	}
+
+	return strings.Join(labels, ",")
+}
+
+func (list stageList) contains(stage Stage) bool {
+	for _, element := range *list.stages {
+		if element == stage {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (list stageList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		stage, ok := findStage(name)
+
+		if !ok {
+			labels := make([]string, 0)
+
+			for _, stage := range stageOrder {
+				labels = append(labels, StageLabels[stage])
+			}
+
+			return fmt.Errorf("unknown stage %q, expected one of %s", name, strings.Join(labels, ", "))
+		}
+
+		// Every stage is emitted once, in the order it was first requested
+		if !list.contains(stage) {
+			*list.stages = append(*list.stages, stage)
+		}
+	}
+
+	return nil
+}
+
 type CompilerSettings struct {
-	Files  []string
-	outdir string
+	Mode  Mode
+	Files []string
+	// Directory for generated files, empty when not given
+	Outdir string
+	// Path of the executable built by MODE_BUILD, empty to derive it from the file name
+	Output      string
+	TraceParser bool
+	// Arguments passed to the program by MODE_RUN, everything after "--"
+	ProgramArgs []string
+	// Stages printed, or written to Outdir, instead of the output of the command
+	Emit []Stage
+	// MODE_FMT lists the files that are not formatted instead of printing them
+	Check bool
+	// MODE_FMT rewrites the files instead of printing them
+	Write bool
 }
 
+// ErrHelp is returned by Parse when help was requested and printed
+var ErrHelp = errors.New("help requested")
+
+// ErrVersion is returned by Parse when the version was requested and printed
+var ErrVersion = errors.New("version requested")
+
 func ParseArguments() CompilerSettings {
-	argv := os.Args
-	argc := len(argv)
+	settings, err := Parse(os.Args[1:], os.Stdout)
+
+	switch {
+	case errors.Is(err, ErrHelp), errors.Is(err, ErrVersion):
+		os.Exit(0)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "castle: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Run 'castle --help' for usage.")
+		os.Exit(2)
+	}
 
+	return settings
+}
+
+// Parse parses the arguments without the program name, help and version go to out
+func Parse(args []string, out io.Writer) (CompilerSettings, error) {
 	settings := CompilerSettings{
 		Files: make([]string, 0),
 	}
 
-	for index := 0; index < argc; index++ {
-		element := argv[index]
+	if len(args) == 0 {
+		return settings, errors.New("no command given")
+	}
 
-		if len(element) < 1 {
-			os.Exit(1)
-		}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		printUsage(out)
+		return settings, ErrHelp
+	case "-version", "--version", "version":
+		fmt.Fprintf(out, "castle %s\n", Version)
+		return settings, ErrVersion
+	}
+
+	mode, ok := findMode(args[0])
+
+	if !ok {
+		return settings, fmt.Errorf("unknown command %q", args[0])
+	}
+
+	settings.Mode = mode
 
-		if util.GetRune(element, 0) == '-' {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
 
-			switch util.GetRune(element, 1) {
-			case 'c':
-				if index+1 >= argc {
-					os.Exit(1)
-				}
-				fileName := argv[index+1]
-				index++
-				settings.Files = append(settings.Files, fileName)
-			case 'd':
-				if index+1 >= argc {
-					os.Exit(1)
-				}
-				settings.outdir = argv[index+1]
-				index++
+	if mode != MODE_FMT {
+		flags.StringVar(&settings.Outdir, "d", "", "")
+		flags.StringVar(&settings.Outdir, "outdir", "", "")
+		flags.BoolVar(&settings.TraceParser, "trace-parser", false, "")
+		flags.Var(stageList{&settings.Emit}, "emit", "")
+	} else {
+		flags.BoolVar(&settings.Check, "check", false, "")
+		flags.BoolVar(&settings.Write, "w", false, "")
+		flags.BoolVar(&settings.Write, "write", false, "")
+	}
+
+	if mode == MODE_BUILD {
+		flags.StringVar(&settings.Output, "o", "", "")
+		flags.StringVar(&settings.Output, "output", "", "")
+	}
+
+	arguments := args[1:]
+
+	for index, element := range arguments {
+		if element == "--" {
+			if mode != MODE_RUN {
+				return settings, fmt.Errorf("%s does not take program arguments", args[0])
 			}
+
+			settings.ProgramArgs = arguments[index+1:]
+			arguments = arguments[:index]
+			break
 		}
 	}
 
-	return settings
+	// Flags may come before or after the files
+	for {
+		err := flags.Parse(arguments)
+
+		if errors.Is(err, flag.ErrHelp) {
+			printCommandUsage(out, mode)
+			return settings, ErrHelp
+		}
+
+		if err != nil {
+			return settings, fmt.Errorf("%s: %s", args[0], err)
+		}
+
+		if flags.NArg() == 0 {
+			break
+		}
+
+		settings.Files = append(settings.Files, flags.Arg(0))
+		arguments = flags.Args()[1:]
+	}
+
+	if len(settings.Files) == 0 {
+		return settings, fmt.Errorf("%s: no files given", args[0])
+	}
+
+	if mode == MODE_RUN && len(settings.Files) != 1 {
+		return settings, errors.New("run: expects exactly one file")
+	}
+
+	if settings.Check && settings.Write {
+		return settings, errors.New("fmt: --check and --write cannot be combined")
+	}
+
+	if settings.Output != "" && len(settings.Files) != 1 {
+		return settings, errors.New("build: --output requires exactly one file")
+	}
+
+	return settings, nil
+}
+
+func findStage(name string) (Stage, bool) {
+	for stage, label := range StageLabels {
+		if label == name {
+			return stage, true
+		}
+	}
+
+	return STAGE_TOKENS, false
+}
+
+func findMode(name string) (Mode, bool) {
+	for mode, label := range ModeLabels {
+		if label == name {
+			return mode, true
+		}
+	}
+
+	return MODE_LEX, false
+}
+
+var modeOrder = []Mode{MODE_LEX, MODE_PARSE, MODE_CHECK, MODE_BUILD, MODE_RUN, MODE_FMT}
+
+func printUsage(out io.Writer) {
+	fmt.Fprintf(out, "castle %s\n\n", Version)
+	fmt.Fprintln(out, "Usage: castle <command> [options] <files>")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+
+	for _, mode := range modeOrder {
+		fmt.Fprintf(out, "  %-8s%s\n", ModeLabels[mode], modeDescriptions[mode])
+	}
+
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Options:")
+	fmt.Fprintln(out, "  --help     Print this help")
+	fmt.Fprintln(out, "  --version  Print the version")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run 'castle <command> --help' for the options of a command.")
+}
+
+func printCommandUsage(out io.Writer, mode Mode) {
+	usage := fmt.Sprintf("castle %s [options] <files>", ModeLabels[mode])
+
+	if mode == MODE_RUN {
+		usage = "castle run [options] <file> [-- <program arguments>]"
+	}
+
+	options := []string{
+		"-d, --outdir <dir>   Directory for generated files",
+		"--trace-parser       Trace the parser to stderr",
+	}
+
+	if mode == MODE_FMT {
+		options = []string{
+			"--check              List the files that are not formatted and fail",
+			"-w, --write          Rewrite the files instead of printing them",
+		}
+	}
+
+	if mode != MODE_FMT {
+		options = append(options, "--emit <stages>      Print tokens, tokens-json, ast, ast-json, ast-dot, ir or c instead of")
+		options = append(options, "                     the usual output, repeatable, written to <outdir> with -d")
+	}
+
+	if mode == MODE_BUILD {
+		options = append(options, "-o, --output <file>  Path of the executable")
+	}
+
+	fmt.Fprintf(out, "%s\n\nUsage: %s\n\nOptions:\n  %s\n", modeDescriptions[mode], usage, strings.Join(options, "\n  "))
 }
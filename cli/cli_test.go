@@ -0,0 +1,113 @@
+package cli
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	settings, err := Parse([]string{"build", "a.cst", "-d", "out", "b.cst", "--trace-parser"}, io.Discard)
+
+	if err != nil {
+		t.Errorf("Parse returned an error: %s", err)
+		return
+	}
+
+	if settings.Mode != MODE_BUILD || settings.Outdir != "out" || !settings.TraceParser {
+		t.Errorf("Parse settings are incorrect %+v", settings)
+	}
+
+	if len(settings.Files) != 2 || settings.Files[0] != "a.cst" || settings.Files[1] != "b.cst" {
+		t.Errorf("Parse files are incorrect %v", settings.Files)
+	}
+}
+
+func TestParseRunArguments(t *testing.T) {
+	settings, err := Parse([]string{"run", "--outdir=out", "main.cst", "--", "-x", "y"}, io.Discard)
+
+	if err != nil {
+		t.Errorf("Parse returned an error: %s", err)
+		return
+	}
+
+	if len(settings.ProgramArgs) != 2 || settings.ProgramArgs[0] != "-x" || settings.Outdir != "out" {
+		t.Errorf("Parse program arguments are incorrect %v", settings.ProgramArgs)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	inputs := [][]string{
+		{},
+		{"frobnicate", "a.cst"},
+		{"parse"},
+		{"parse", "--bogus", "a.cst"},
+		{"run", "a.cst", "b.cst"},
+		{"check", "a.cst", "--", "x"},
+		{"parse", "-o", "x", "a.cst"},
+	}
+
+	for _, input := range inputs {
+		if _, err := Parse(input, io.Discard); err == nil || errors.Is(err, ErrHelp) {
+			t.Errorf("Parse did not return a usage error for %v", input)
+		}
+	}
+
+	if _, err := Parse([]string{"--help"}, io.Discard); !errors.Is(err, ErrHelp) {
+		t.Error("Parse did not handle --help")
+	}
+
+	if _, err := Parse([]string{"lex", "-h"}, io.Discard); !errors.Is(err, ErrHelp) {
+		t.Error("Parse did not handle command help")
+	}
+
+	if _, err := Parse([]string{"--version"}, io.Discard); !errors.Is(err, ErrVersion) {
+		t.Error("Parse did not handle --version")
+	}
+}
+
+func TestParseEmit(t *testing.T) {
+	settings, err := Parse([]string{"check", "--emit=ir", "a.cst", "--emit", "tokens,c,ir"}, io.Discard)
+
+	if err != nil {
+		t.Errorf("Parse returned an error: %s", err)
+		return
+	}
+
+	expected := []Stage{STAGE_IR, STAGE_TOKENS, STAGE_C}
+
+	if len(settings.Emit) != len(expected) {
+		t.Errorf("Parse emit stages are incorrect %v", settings.Emit)
+		return
+	}
+
+	for index, stage := range expected {
+		if settings.Emit[index] != stage {
+			t.Errorf("Parse emit stage %d is %s, expected %s", index, StageLabels[settings.Emit[index]], StageLabels[stage])
+		}
+	}
+
+	if _, err := Parse([]string{"check", "--emit=bytecode", "a.cst"}, io.Discard); err == nil {
+		t.Error("Parse accepted an unknown stage")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	settings, err := Parse([]string{"fmt", "-w", "a.cst"}, io.Discard)
+
+	if err != nil || !settings.Write || settings.Check {
+		t.Errorf("Parse fmt settings are incorrect %+v %v", settings, err)
+	}
+
+	if _, err := Parse([]string{"fmt", "--check", "--write", "a.cst"}, io.Discard); err == nil {
+		t.Error("Parse accepted --check with --write")
+	}
+
+	if _, err := Parse([]string{"fmt", "--emit=c", "a.cst"}, io.Discard); err == nil {
+		t.Error("Parse accepted --emit for fmt")
+	}
+
+	if _, err := Parse([]string{"fmt", "-d", "out", "a.cst"}, io.Discard); err == nil {
+		t.Error("Parse accepted -d for fmt")
+	}
+}
@@ -2,19 +2,33 @@ package codegen
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/milansav/Castle/diagnostic"
 	"github.com/milansav/Castle/lexer"
 	"github.com/milansav/Castle/parser"
+	"github.com/milansav/Castle/util"
 )
 
 type Codegen struct {
-	Program   *parser.AST_Program
-	OutBuffer string
+	Program     *parser.AST_Program
+	OutBuffer   string
+	Diagnostics []diagnostic.Diagnostic
+	// Source file name, when set #line directives map the C output back to it
+	File        string
+	indentation int
+	// Number of for loops emitted, numbers their hidden variables
+	loops int
+	// Top level declarations emitted as C globals, main only assigns them
+	globals map[*parser.AST_Declaration]bool
 }
 
 func Create(program *parser.AST_Program) Codegen {
 	return Codegen{
 		Program: program,
+		globals: make(map[*parser.AST_Declaration]bool),
 	}
 }
 
@@ -22,54 +36,377 @@ func (c *Codegen) Out(text string) {
 	c.OutBuffer += text
 }
 
+func (codegen *Codegen) Indent() {
+	codegen.Out(strings.Repeat("    ", codegen.indentation))
+}
+
+// Line emits a #line directive so C compiler messages point at the Castle source
+func (codegen *Codegen) Line(span diagnostic.Span) {
+	if codegen.File == "" || span.Start.Row == 0 {
+		return
+	}
+
+	codegen.Out(fmt.Sprintf("#line %d %s\n", span.Start.Row, quote(codegen.File)))
+}
+
+func (codegen *Codegen) report(span diagnostic.Span, format string, args ...interface{}) {
+	codegen.Diagnostics = append(codegen.Diagnostics, diagnostic.Error(diagnostic.CODE_UNSUPPORTED, span, format, args...))
+}
+
+/*
+
+Top level structs are emitted first as typedefs, ordered so that structs come
+after the structs they contain. Interfaces come before them, as a vtable of
+function pointers taking the value as a void* and a struct holding the value
+and the vtable of its struct. Every struct used as an interface gets a vtable
+of functions forwarding to its fields and a function converting its values,
+which copies them to the heap. The copies are never freed, interface values
+are copied around like any other value and nothing tracks which one is last.
+Top level functions are emitted as C functions, preceded by prototypes so they
+can be called before their definition. The remaining top level statements go
+into a generated main, top level declarations become globals that main assigns
+so functions can use them. When the program defines main itself only
+declarations are allowed outside of it and they become initialized globals.
+
+*/
+
 func (codegen *Codegen) Start() {
 
-	// c.Out("int main (int argc, char **argv) {\n")
-	// c.Out("return 0;\n")
-	// c.Out("}\n")
+	codegen.Out("#include <stdbool.h>\n")
+	codegen.Out("#include <stddef.h>\n")
+	codegen.Out("#include <stdint.h>\n")
+	codegen.Out("#include <stdio.h>\n")
+	codegen.Out("#include <stdlib.h>\n")
+	codegen.Out("\n")
 
-	codegen.Out("#define bool int\n")
-	codegen.Out("#define true 1\n")
-	codegen.Out("#define false 0\n")
-	codegen.Out("#define NULL 0\n")
+	functions := make([]*parser.AST_Declaration, 0)
+	structs := make([]*parser.AST_Struct, 0)
+	interfaces := make([]*parser.AST_Struct, 0)
+	statements := make([]*parser.AST_Statement, 0)
+	hasMain := false
 
 	for _, programStatement := range codegen.Program.Statements {
+		if programStatement.SType == parser.ST_STRUCT {
+			structs = append(structs, programStatement.Struct)
+			continue
+		}
+
+		if programStatement.SType == parser.ST_INTERFACE {
+			interfaces = append(interfaces, programStatement.Struct)
+			continue
+		}
+
+		if function := declaredFunction(programStatement); function != nil {
+			functions = append(functions, programStatement.Declaration)
+			hasMain = hasMain || programStatement.Declaration.Name == "main"
+			continue
+		}
 
-		codegen.PrintStatement(programStatement)
+		statements = append(statements, programStatement)
+	}
+
+	if len(interfaces) > 0 {
+		// Interfaces may take and return structs declared after them
+		for _, structure := range structs {
+			codegen.Out(fmt.Sprintf("typedef struct %s %s;\n", mangle(structure.Name), mangle(structure.Name)))
+		}
+
+		codegen.Out("\n")
 	}
-}
 
-func findFirstLiteral(node interface{}) *parser.AST_Literal {
-	switch n := node.(type) {
-	case *parser.AST_Literal:
-		return n
-	case *parser.AST_FunctionCall:
-		for _, param := range n.Params {
-			if result := findFirstLiteral(param); result != nil {
-				return result
+	for _, contract := range interfaces {
+		codegen.PrintInterface(contract)
+		codegen.Out("\n")
+	}
+
+	for _, structure := range orderStructs(structs) {
+		codegen.PrintStruct(structure)
+		codegen.Out("\n")
+	}
+
+	for _, contract := range interfaces {
+		codegen.PrintDispatch(contract)
+	}
+
+	codegen.PrintConversions(structs, interfaces)
+
+	for _, function := range functions {
+		codegen.PrintFunctionSignature(function.Name, function.Value.Value.Function)
+		codegen.Out(";\n")
+	}
+
+	if len(functions) > 0 {
+		codegen.Out("\n")
+	}
+
+	if !hasMain {
+		// Top level declarations are globals so functions can use them, they
+		// are assigned in main where their values can be computed
+		for _, statement := range statements {
+			if statement.SType != parser.ST_DECLARATION {
+				continue
 			}
+
+			global := declarationType(statement.Declaration)
+
+			if strings.HasPrefix(global, "__auto_type") {
+				continue
+			}
+
+			codegen.globals[statement.Declaration] = true
+			codegen.Out(global + ";\n")
 		}
-	case *parser.AST_Expression:
-		if n.Lhs != nil {
-			if result := findFirstLiteral(n.Lhs); result != nil {
-				return result
+
+		if len(codegen.globals) > 0 {
+			codegen.Out("\n")
+		}
+	}
+
+	if hasMain {
+		for _, statement := range statements {
+			if statement.SType != parser.ST_DECLARATION {
+				codegen.report(statement.Span, "statements outside of functions are not allowed when main is defined")
+				continue
 			}
+
+			codegen.PrintStatement(statement)
+		}
+
+		if len(statements) > 0 {
+			codegen.Out("\n")
+		}
+	}
+
+	for _, function := range functions {
+		codegen.PrintFunction(function.Name, function.Value.Value.Function)
+		codegen.Out("\n")
+	}
+
+	if !hasMain {
+		codegen.Out("int main(void) {\n")
+		codegen.indentation++
+
+		for _, statement := range statements {
+			codegen.PrintStatement(statement)
+		}
+
+		codegen.Indent()
+		codegen.Out("return 0;\n")
+
+		codegen.indentation--
+		codegen.Out("}\n")
+	}
+}
+
+// declaredFunction returns the function of a "const name = (...) => ..." statement
+func declaredFunction(statement *parser.AST_Statement) *parser.AST_Function {
+	if statement.SType != parser.ST_DECLARATION || statement.Declaration.Value == nil {
+		return nil
+	}
+
+	value := statement.Declaration.Value
+
+	if value.EType != parser.ET_VALUE || value.Value.Type != parser.TYPE_FUNCTION {
+		return nil
+	}
+
+	return value.Value.Function
+}
+
+func findFirstLiteral(node parser.Node) *parser.AST_Value {
+	var literal *parser.AST_Value
+
+	parser.Inspect(node, func(node parser.Node) bool {
+		if value, ok := node.(*parser.AST_Value); ok && literal == nil {
+			literal = value
 		}
-		if n.Rhs != nil {
-			if result := findFirstLiteral(n.Rhs); result != nil {
-				return result
+
+		return literal == nil
+	})
+
+	return literal
+}
+
+// orderStructs sorts the structs so that every struct comes after the
+// structs its fields contain, otherwise keeping the source order
+func orderStructs(structs []*parser.AST_Struct) []*parser.AST_Struct {
+	byName := make(map[string]*parser.AST_Struct)
+
+	for _, structure := range structs {
+		byName[structure.Name] = structure
+	}
+
+	result := make([]*parser.AST_Struct, 0, len(structs))
+	visited := make(map[*parser.AST_Struct]bool)
+
+	var visit func(structure *parser.AST_Struct)
+	visit = func(structure *parser.AST_Struct) {
+		if visited[structure] {
+			return
+		}
+
+		visited[structure] = true
+
+		for _, field := range structure.Fields {
+			if contained, ok := byName[field.Type.Name]; ok && field.Type.Type == parser.TYPE_STRUCT {
+				visit(contained)
 			}
 		}
-		if n.Value != nil {
-			return n.Value
+
+		result = append(result, structure)
+	}
+
+	for _, structure := range structs {
+		visit(structure)
+	}
+
+	return result
+}
+
+// findReturn returns the first return statement of a function body, nested functions excluded
+func findReturn(statement *parser.AST_Statement) *parser.AST_Statement {
+	var result *parser.AST_Statement
+
+	parser.Inspect(statement, func(node parser.Node) bool {
+		if result != nil {
+			return false
 		}
-		if n.FunctionCall != nil {
-			if result := findFirstLiteral(n.FunctionCall); result != nil {
-				return result
+
+		switch n := node.(type) {
+		case *parser.AST_Statement:
+			if n.SType == parser.ST_RETURN {
+				result = n
+				return false
 			}
+
+			// Nested functions have their own returns
+			return n.SType != parser.ST_FUNCTION && n.SType != parser.ST_DECLARATION
+		case *parser.AST_If:
+			return true
+		}
+
+		return false
+	})
+
+	return result
+}
+
+var suffixTypes = map[string]string{
+	"u8":  "uint8_t",
+	"u16": "uint16_t",
+	"u32": "uint32_t",
+	"u64": "uint64_t",
+	"i8":  "int8_t",
+	"i16": "int16_t",
+	"i32": "int32_t",
+	"i64": "int64_t",
+	"f32": "float",
+	"f64": "double",
+}
+
+// cType returns the C type of a type inferred by the type checker
+func cType(t *parser.AST_Type) string {
+	if cType, ok := suffixTypes[t.Name]; ok {
+		return cType
+	}
+
+	switch t.Type {
+	case parser.TYPE_NUMBER:
+		return "int"
+	case parser.TYPE_FLOAT:
+		return "double"
+	case parser.TYPE_STRING:
+		return "char*"
+	case parser.TYPE_BOOL:
+		return "bool"
+	case parser.TYPE_VOID:
+		return "void"
+	case parser.TYPE_STRUCT, parser.TYPE_INTERFACE:
+		return mangle(t.Name)
+	default:
+		return "__auto_type"
+	}
+}
+
+// declarator returns the C declaration of name with type t, e.g. "int (*f)(int, int)"
+func declarator(t *parser.AST_Type, name string) string {
+	if t.Type != parser.TYPE_FUNCTION {
+		return strings.TrimSpace(cType(t) + " " + name)
+	}
+
+	params := make([]string, 0, len(t.Params))
+
+	for _, param := range t.Params {
+		params = append(params, declarator(param, ""))
+	}
+
+	if len(params) == 0 {
+		params = append(params, "void")
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s (*%s)(%s)", cType(t.Result), name, strings.Join(params, ", ")))
+}
+
+// declarationType returns the C declaration of the name of a declaration, e.g. "int x"
+func declarationType(declaration *parser.AST_Declaration) string {
+	if t := declaration.Type; t != nil {
+		return declarator(t, mangle(declaration.Name))
+	} else if t := declaration.Value.Type; t != nil {
+		return declarator(t, mangle(declaration.Name))
+	}
+
+	return valueType(declaration.Value) + " " + mangle(declaration.Name)
+}
+
+// valueType returns the C type of an expression, expressions the type checker
+// has not seen get a guess from the first literal in them
+func valueType(expression *parser.AST_Expression) string {
+	if expression.Type != nil {
+		return cType(expression.Type)
+	}
+
+	literal := findFirstLiteral(expression)
+
+	if literal == nil {
+		return "__auto_type"
+	}
+
+	if cType, ok := suffixTypes[literal.Suffix]; ok {
+		return cType
+	}
+
+	switch literal.Type {
+	case parser.TYPE_NUMBER:
+		return "int"
+	case parser.TYPE_FLOAT:
+		return "double"
+	case parser.TYPE_STRING:
+		return "char*"
+	case parser.TYPE_BOOL:
+		return "bool"
+	default:
+		return "__auto_type"
+	}
+}
+
+// mangle turns a Castle identifier into a valid C identifier
+func mangle(name string) string {
+	if util.IsReservedC(name) {
+		return "castle_" + name
+	}
+
+	builder := strings.Builder{}
+
+	for _, c := range name {
+		if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_') {
+			builder.WriteRune(c)
+			continue
 		}
+
+		fmt.Fprintf(&builder, "_u%04X", c)
 	}
-	return nil
+
+	return builder.String()
 }
 
 func stringifyOperator(lt lexer.LexemeType) string {
@@ -100,114 +437,648 @@ func stringifyOperator(lt lexer.LexemeType) string {
 		return "<"
 	case lexer.LT_RCHEVRON:
 		return ">"
+	case lexer.LT_BANG:
+		return "!"
+	case lexer.LT_EQUALS:
+		return "="
+	case lexer.LT_PLUS_EQUALS:
+		return "+="
+	case lexer.LT_MINUS_EQUALS:
+		return "-="
+	case lexer.LT_MULTIPLY_EQUALS:
+		return "*="
+	case lexer.LT_DIVIDE_EQUALS:
+		return "/="
+	case lexer.LT_MODULO_EQUALS:
+		return "%="
 	default:
-		panic(fmt.Errorf("error: unknown operator %s", lexer.LexemeTypeLabels[lt]))
+		return ""
+	}
+}
+
+func (codegen *Codegen) PrintFunctionSignature(name string, function *parser.AST_Function) {
+	if name == "main" && len(function.Props) == 2 {
+		codegen.Out(fmt.Sprintf("int main(int %s, char** %s)", mangle(function.Props[0].Name), mangle(function.Props[1].Name)))
+		return
+	}
+
+	returnType := "void"
+
+	if name == "main" {
+		returnType = "int"
+	} else if function.Type != nil {
+		returnType = cType(function.Type.Result)
+	} else if body := function.ExpressionBody(); body != nil {
+		returnType = valueType(body)
+	} else if statement := findReturn(function.Statement); statement != nil {
+		returnType = valueType(statement.Expression)
+	}
+
+	if returnType == "__auto_type" {
+		returnType = "int"
+	}
+
+	codegen.Out(returnType)
+	codegen.Out(" ")
+	codegen.Out(mangle(name))
+	codegen.Out("(")
+
+	if len(function.Props) == 0 {
+		codegen.Out("void")
+	}
+
+	for index, prop := range function.Props {
+		if index > 0 {
+			codegen.Out(", ")
+		}
+
+		if function.Type != nil {
+			codegen.Out(declarator(function.Type.Params[index], mangle(prop.Name)))
+			continue
+		}
+
+		codegen.Out("int ")
+		codegen.Out(mangle(prop.Name))
+	}
+
+	codegen.Out(")")
+}
+
+func returnsVoid(name string, function *parser.AST_Function) bool {
+	return name != "main" && function.Type != nil && function.Type.Result.Type == parser.TYPE_VOID
+}
+
+func (codegen *Codegen) PrintFunction(name string, function *parser.AST_Function) {
+	codegen.Line(function.Span)
+	codegen.Indent()
+	codegen.PrintFunctionSignature(name, function)
+	codegen.Out(" {\n")
+	codegen.indentation++
+
+	if body := function.ExpressionBody(); body != nil {
+		codegen.Line(body.Span)
+		codegen.Indent()
+
+		if !returnsVoid(name, function) {
+			codegen.Out("return ")
+		}
+
+		codegen.PrintExpression(body)
+		codegen.Out(";\n")
+	} else {
+		codegen.PrintStatement(function.Statement)
+	}
+
+	if name == "main" && findReturn(function.Statement) == nil {
+		codegen.Indent()
+		codegen.Out("return 0;\n")
 	}
+
+	codegen.indentation--
+	codegen.Indent()
+	codegen.Out("}\n")
 }
 
 func (codegen *Codegen) PrintStatement(statement *parser.AST_Statement) {
+	if statement.SType != parser.ST_STATEMENT_ARRAY && statement.SType != parser.ST_STATEMENT {
+		codegen.Line(statement.Span)
+	}
+
 	switch statement.SType {
 	case parser.ST_STATEMENT_ARRAY:
 		for _, statement := range statement.Statements {
 			codegen.PrintStatement(statement)
 		}
 	case parser.ST_STATEMENT:
+		codegen.PrintStatement(statement.Statement)
 	case parser.ST_EXPRESSION:
+		codegen.Indent()
 		codegen.PrintExpression(statement.Expression)
 		codegen.Out(";\n")
 	case parser.ST_FUNCTION:
-		codegen.Out("void ")
-		codegen.Out(statement.Function.Name)
-		codegen.Out("() {\n")
-		if statement.Function.Statement.Statement != nil {
-			codegen.Out("return ")
-			codegen.PrintStatement(statement.Function.Statement.Statement)
-			codegen.Out(";\n")
+		codegen.PrintFunction(statement.Function.Name, statement.Function)
+	case parser.ST_DECLARATION:
+		if function := declaredFunction(statement); function != nil {
+			// Nested functions rely on the GNU C extension
+			codegen.PrintFunction(statement.Declaration.Name, function)
+			return
+		}
+
+		declaration := statement.Declaration
+
+		codegen.Indent()
+
+		if !codegen.globals[declaration] {
+			codegen.Out(declarationType(declaration))
 		} else {
-			codegen.PrintStatement(statement.Function.Statement)
+			codegen.Out(mangle(declaration.Name))
 		}
-		codegen.Out("}\n")
-	case parser.ST_DECLARATION:
-		e := findFirstLiteral(statement.Declaration.Value)
 
-		if e == nil {
-			panic(fmt.Errorf("could not find literal in declaration %s", statement.Declaration.Name))
+		if declaration.Value != nil {
+			codegen.Out(" = ")
+			codegen.PrintExpression(declaration.Value)
 		}
 
-		switch e.Type {
-		case parser.TYPE_NUMBER:
-			codegen.Out("int ")
-		case parser.TYPE_FLOAT:
-			codegen.Out("float ")
-		case parser.TYPE_STRING:
-			codegen.Out("char* ")
-		case parser.TYPE_BOOL:
-			codegen.Out("bool ")
-		case parser.TYPE_UNDEFINED:
-			codegen.Out("void* ")
+		codegen.Out(";\n")
+	case parser.ST_ASSIGNMENT:
+		codegen.Indent()
+		codegen.PrintExpression(statement.Assignment.Target)
+		codegen.Out(" ")
+		codegen.Out(stringifyOperator(statement.Assignment.Operator))
+		codegen.Out(" ")
+		codegen.PrintExpression(statement.Assignment.Value)
+		codegen.Out(";\n")
+	case parser.ST_STRUCT:
+		codegen.PrintStruct(statement.Struct)
+	case parser.ST_INTERFACE:
+		codegen.report(statement.Span, "interfaces are only supported at the top level by the C backend")
+	case parser.ST_IF:
+		codegen.Indent()
+
+		for branch := statement.If; branch != nil; branch = branch.Else {
+			if branch != statement.If && branch.Condition != nil && codegen.File != "" {
+				// #line has to start a line, so else if goes on its own
+				codegen.Out("\n")
+				codegen.Line(branch.Condition.Span)
+				codegen.Indent()
+				codegen.Out("else ")
+			} else if branch != statement.If {
+				codegen.Out(" else ")
+			}
+
+			if branch.Condition != nil {
+				codegen.Out("if (")
+				codegen.PrintExpression(branch.Condition)
+				codegen.Out(") ")
+			}
+
+			codegen.PrintBlock(branch.Statements)
 		}
 
-		codegen.Out(statement.Declaration.Name)
+		codegen.Out("\n")
+	case parser.ST_WHILE:
+		codegen.Indent()
+		codegen.Out("while (")
+		codegen.PrintExpression(statement.Loop.Condition)
+		codegen.Out(") ")
+		codegen.PrintBlock(statement.Loop.Statements)
+		codegen.Out("\n")
+	case parser.ST_FOR:
+		codegen.PrintFor(statement.Loop)
+	case parser.ST_BREAK:
+		codegen.Indent()
+		codegen.Out("break;\n")
+	case parser.ST_CONTINUE:
+		codegen.Indent()
+		codegen.Out("continue;\n")
+	case parser.ST_RETURN:
+		codegen.Indent()
+		codegen.Out("return ")
+		codegen.PrintExpression(statement.Expression)
+		codegen.Out(";\n")
+	case parser.ST_ERROR:
+		codegen.report(statement.Span, "cannot generate code for an invalid statement")
+	}
+}
 
-		codegen.Out(" = ")
+// PrintStruct prints the typedef of a struct declaration
+func (codegen *Codegen) PrintStruct(structure *parser.AST_Struct) {
+	name := mangle(structure.Name)
 
-		codegen.PrintExpression(statement.Declaration.Value)
+	codegen.Indent()
+	codegen.Out(fmt.Sprintf("typedef struct %s {\n", name))
+	codegen.indentation++
 
+	for _, field := range structure.Fields {
+		codegen.Indent()
+		codegen.Out(declarator(field.Type, mangle(field.Name)))
 		codegen.Out(";\n")
-	case parser.ST_STRUCT:
-	case parser.ST_IF:
-		codegen.Out("if (")
-		codegen.PrintExpression(statement.If.Condition)
-		codegen.Out(") {\n")
-		for _, statement := range statement.If.Statements {
+	}
+
+	codegen.indentation--
+	codegen.Indent()
+	codegen.Out(fmt.Sprintf("} %s;\n", name))
+}
+
+// PrintInterface prints the vtable type of an interface and the interface type
+func (codegen *Codegen) PrintInterface(contract *parser.AST_Struct) {
+	name := mangle(contract.Name)
+
+	codegen.Out(fmt.Sprintf("typedef struct castle_%s_vtable {\n", name))
+
+	for _, member := range contract.Fields {
+		params := append([]string{"void*"}, paramTypes(member.Type)...)
+
+		codegen.Out(fmt.Sprintf("    %s (*%s)(%s);\n", cType(member.Type.Result), mangle(member.Name), strings.Join(params, ", ")))
+	}
+
+	codegen.Out(fmt.Sprintf("} castle_%s_vtable;\n\n", name))
+	codegen.Out(fmt.Sprintf("typedef struct %s {\n", name))
+	codegen.Out("    void* self;\n")
+	codegen.Out(fmt.Sprintf("    const castle_%s_vtable* vtable;\n", name))
+	codegen.Out(fmt.Sprintf("} %s;\n", name))
+}
+
+// PrintDispatch prints a function per member of the interface, calling the
+// member of the vtable of a value, e.g. castle_Shape_area(shape)
+func (codegen *Codegen) PrintDispatch(contract *parser.AST_Struct) {
+	name := mangle(contract.Name)
+
+	for _, member := range contract.Fields {
+		params, arguments := signature(member.Type)
+		call := fmt.Sprintf("value.vtable->%s(%s)", mangle(member.Name), strings.Join(append([]string{"value.self"}, arguments...), ", "))
+
+		codegen.Out(fmt.Sprintf("static %s castle_%s_%s(%s) {\n", cType(member.Type.Result), name, mangle(member.Name), strings.Join(append([]string{name + " value"}, params...), ", ")))
+		codegen.Out(fmt.Sprintf("    %s;\n", returning(member.Type, call)))
+		codegen.Out("}\n\n")
+	}
+}
+
+// PrintConversions prints the vtables of the structs used as interfaces and
+// the functions converting their values, e.g. castle_Square_as_Shape(square)
+func (codegen *Codegen) PrintConversions(structs []*parser.AST_Struct, interfaces []*parser.AST_Struct) {
+	declared := make(map[string]*parser.AST_Struct)
+
+	for _, declaration := range append(append([]*parser.AST_Struct{}, structs...), interfaces...) {
+		declared[declaration.Name] = declaration
+	}
+
+	printed := make(map[string]bool)
+
+	parser.Inspect(codegen.Program, func(node parser.Node) bool {
+		expression, ok := node.(*parser.AST_Expression)
+
+		if !ok || expression.Interface == nil || expression.Type == nil {
+			return true
+		}
+
+		conversion := conversionName(expression.Type, expression.Interface)
+		structure, contract := declared[expression.Type.Name], declared[expression.Interface.Name]
+
+		if printed[conversion] {
+			return true
+		}
+
+		printed[conversion] = true
+
+		if structure == nil || contract == nil {
+			codegen.report(expression.Span, "only top level structs can be used as interfaces by the C backend")
+			return true
+		}
+
+		codegen.PrintConversion(structure, contract)
+
+		return true
+	})
+}
+
+// PrintConversion prints the vtable of the struct for the interface and the function converting its values
+func (codegen *Codegen) PrintConversion(structure *parser.AST_Struct, contract *parser.AST_Struct) {
+	name, interfaceName := mangle(structure.Name), mangle(contract.Name)
+	prefix := fmt.Sprintf("castle_%s_%s", name, interfaceName)
+
+	for _, member := range contract.Fields {
+		params, arguments := signature(member.Type)
+		call := fmt.Sprintf("((%s*)self)->%s(%s)", name, mangle(member.Name), strings.Join(arguments, ", "))
+
+		codegen.Out(fmt.Sprintf("static %s %s_%s(%s) {\n", cType(member.Type.Result), prefix, mangle(member.Name), strings.Join(append([]string{"void* self"}, params...), ", ")))
+		codegen.Out(fmt.Sprintf("    %s;\n", returning(member.Type, call)))
+		codegen.Out("}\n\n")
+	}
+
+	codegen.Out(fmt.Sprintf("static const castle_%s_vtable %s_vtable = {\n", interfaceName, prefix))
+
+	for _, member := range contract.Fields {
+		codegen.Out(fmt.Sprintf("    %s_%s,\n", prefix, mangle(member.Name)))
+	}
+
+	codegen.Out("};\n\n")
+
+	codegen.Out(fmt.Sprintf("static %s %s(%s value) {\n", interfaceName, conversionName(&parser.AST_Type{Name: structure.Name}, &parser.AST_Type{Name: contract.Name}), name))
+	codegen.Out("    // Never freed, copies of the interface value share it\n")
+	codegen.Out(fmt.Sprintf("    %s* self = malloc(sizeof(%s));\n", name, name))
+	codegen.Out("    *self = value;\n")
+	codegen.Out(fmt.Sprintf("    return (%s){self, &%s_vtable};\n", interfaceName, prefix))
+	codegen.Out("}\n\n")
+}
+
+func conversionName(structure *parser.AST_Type, contract *parser.AST_Type) string {
+	return fmt.Sprintf("castle_%s_as_%s", mangle(structure.Name), mangle(contract.Name))
+}
+
+// paramTypes returns the C types of the parameters of a function type
+func paramTypes(t *parser.AST_Type) []string {
+	params := make([]string, 0, len(t.Params))
+
+	for _, param := range t.Params {
+		params = append(params, declarator(param, ""))
+	}
+
+	return params
+}
+
+// signature returns the parameters of a function type named p0, p1, ... and their names
+func signature(t *parser.AST_Type) ([]string, []string) {
+	params := make([]string, 0, len(t.Params))
+	names := make([]string, 0, len(t.Params))
+
+	for index, param := range t.Params {
+		names = append(names, fmt.Sprintf("p%d", index))
+		params = append(params, declarator(param, names[index]))
+	}
+
+	return params, names
+}
+
+// returning returns the statement returning the call, or only making it for void functions
+func returning(t *parser.AST_Type, call string) string {
+	if t.Result.Type == parser.TYPE_VOID {
+		return call
+	}
+
+	return "return " + call
+}
+
+// PrintBlock prints the statements in braces, without a trailing newline
+func (codegen *Codegen) PrintBlock(statements []*parser.AST_Statement) {
+	codegen.Out("{\n")
+	codegen.indentation++
+	for _, statement := range statements {
+		codegen.PrintStatement(statement)
+	}
+	codegen.indentation--
+	codegen.Indent()
+	codegen.Out("}")
+}
+
+// PrintFor prints a for-of loop. Ints count from 0 to the int, evaluated
+// once, and strings are walked with a pointer up to the terminating zero.
+func (codegen *Codegen) PrintFor(loop *parser.AST_Loop) {
+	name := mangle(loop.Variable.Name)
+	index := codegen.loops
+	codegen.loops++
+
+	codegen.Indent()
+
+	if loop.Collection.Type != nil && loop.Collection.Type.Type == parser.TYPE_STRING {
+		iterator := fmt.Sprintf("castle_it%d", index)
+
+		codegen.Out(fmt.Sprintf("for (const char* %s = ", iterator))
+		codegen.PrintExpression(loop.Collection)
+		codegen.Out(fmt.Sprintf("; *%s; %s++) {\n", iterator, iterator))
+		codegen.indentation++
+		codegen.Indent()
+		codegen.Out(fmt.Sprintf("int %s = *%s;\n", name, iterator))
+		for _, statement := range loop.Statements {
 			codegen.PrintStatement(statement)
 		}
+		codegen.indentation--
+		codegen.Indent()
 		codegen.Out("}\n")
+
+		return
 	}
+
+	end := fmt.Sprintf("castle_end%d", index)
+	t := valueType(loop.Collection)
+
+	codegen.Out(fmt.Sprintf("for (%s %s = 0, %s = ", t, name, end))
+	codegen.PrintExpression(loop.Collection)
+	codegen.Out(fmt.Sprintf("; %s < %s; %s++) ", name, end, name))
+	codegen.PrintBlock(loop.Statements)
+	codegen.Out("\n")
 }
 
 func (codegen *Codegen) PrintExpression(expression *parser.AST_Expression) {
+	if expression.Interface != nil && expression.Type != nil {
+		converted := *expression
+		converted.Interface = nil
+
+		codegen.Out(conversionName(expression.Type, expression.Interface) + "(")
+		codegen.PrintExpression(&converted)
+		codegen.Out(")")
+
+		return
+	}
 
 	switch expression.EType {
 	case parser.ET_BINARY:
-		codegen.PrintExpression(expression.Lhs)
-		codegen.Out(" ")
+		codegen.PrintBinary(expression)
+	case parser.ET_UNARY:
 		codegen.Out(stringifyOperator(expression.Operator))
-		codegen.Out(" ")
-		codegen.PrintExpression(expression.Rhs)
+
+		// - -1 would be --1, a decrement in C
+		if expression.Rhs.EType == parser.ET_UNARY {
+			codegen.Out("(")
+			codegen.PrintExpression(expression.Rhs)
+			codegen.Out(")")
+		} else {
+			codegen.PrintExpression(expression.Rhs)
+		}
 	case parser.ET_VALUE:
-		codegen.PrintLiteral(expression.Value)
+		codegen.PrintLiteral(expression.Value, expression.Span)
 	case parser.ET_FUNCTION_CALL:
 		codegen.PrintFunctionCall(expression.FunctionCall)
 	case parser.ET_IDENTIFIER:
-		codegen.Out(expression.Identifier)
+		codegen.Out(mangle(expression.Identifier))
+	case parser.ET_GROUP:
+		codegen.Out("(")
+		codegen.PrintExpression(expression.Lhs)
+		codegen.Out(")")
+	case parser.ET_EXPRESSION_ARRAY:
+		codegen.PrintExpression(expression.Lhs)
+		codegen.Out(", ")
+		codegen.PrintExpression(expression.Rhs)
+	case parser.ET_MEMBER_ACCESS:
+		codegen.PrintMemberAccess(expression)
+	case parser.ET_ERROR:
+		codegen.report(expression.Span, "cannot generate code for an invalid expression")
+	}
+
+}
+
+func (codegen *Codegen) PrintBinary(expression *parser.AST_Expression) {
+	// Logical operators without a C counterpart are spelled out
+	switch expression.Operator {
+	case lexer.LT_NAND, lexer.LT_NOR:
+		codegen.Out("!(")
+		codegen.PrintExpression(expression.Lhs)
+		codegen.Out(map[lexer.LexemeType]string{lexer.LT_NAND: " && ", lexer.LT_NOR: " || "}[expression.Operator])
+		codegen.PrintExpression(expression.Rhs)
+		codegen.Out(")")
+		return
+	case lexer.LT_XOR, lexer.LT_XNOR, lexer.LT_XAND, lexer.LT_XNAND:
+		codegen.Out("(!(")
+		codegen.PrintExpression(expression.Lhs)
+		codegen.Out(map[lexer.LexemeType]string{
+			lexer.LT_XOR:   ") != !(",
+			lexer.LT_XNAND: ") != !(",
+			lexer.LT_XNOR:  ") == !(",
+			lexer.LT_XAND:  ") == !(",
+		}[expression.Operator])
+		codegen.PrintExpression(expression.Rhs)
+		codegen.Out("))")
+		return
+	}
+
+	operator := stringifyOperator(expression.Operator)
+
+	if operator == "" {
+		codegen.report(expression.Span, "operator %s is not supported by the C backend", lexer.LexemeTypeLabels[expression.Operator])
+	}
+
+	codegen.PrintExpression(expression.Lhs)
+	codegen.Out(" ")
+	codegen.Out(operator)
+	codegen.Out(" ")
+	codegen.PrintExpression(expression.Rhs)
+}
+
+// PrintMemberAccess prints a.b.c, see parser.memberAccess for the shape of the tree
+func (codegen *Codegen) PrintMemberAccess(expression *parser.AST_Expression) {
+	links := make([]*parser.AST_Expression, 0)
+
+	for member := expression.Rhs; member != nil; member = member.Rhs {
+		links = append(links, member)
 	}
 
+	codegen.printMembers(expression, links)
 }
 
-func (codegen *Codegen) PrintLiteral(literal *parser.AST_Literal) {
+// printMembers prints the root of a member access followed by the links,
+// calls of interface members go through the dispatch function of the interface
+func (codegen *Codegen) printMembers(expression *parser.AST_Expression, links []*parser.AST_Expression) {
+	if len(links) > 0 {
+		link := links[len(links)-1]
+		member := link.Lhs
+
+		if link.Type == nil || link.Type.Type != parser.TYPE_INTERFACE {
+			codegen.printMembers(expression, links[:len(links)-1])
+			codegen.Out(".")
+			codegen.PrintExpression(member)
+
+			return
+		}
+
+		if member.FunctionCall == nil {
+			codegen.report(member.Span, "members of interfaces can only be called")
+			return
+		}
+
+		codegen.Out(fmt.Sprintf("castle_%s_%s(", mangle(link.Type.Name), mangle(member.FunctionCall.Name)))
+		codegen.printMembers(expression, links[:len(links)-1])
+
+		for _, param := range member.FunctionCall.Params {
+			codegen.Out(", ")
+			codegen.PrintExpression(param)
+		}
+
+		codegen.Out(")")
+
+		return
+	}
+
+	root := *expression
+	root.EType = parser.ET_IDENTIFIER
+
+	if expression.FunctionCall != nil {
+		root.EType = parser.ET_FUNCTION_CALL
+	} else if expression.Value != nil {
+		root.EType = parser.ET_VALUE
+	}
+
+	codegen.PrintExpression(&root)
+}
+
+func (codegen *Codegen) PrintLiteral(literal *parser.AST_Value, span diagnostic.Span) {
 	switch literal.Type {
 	case parser.TYPE_NUMBER:
-		codegen.Out(literal.Value)
+		number := strconv.FormatUint(literal.Integer, 10)
+
+		if cType, ok := suffixTypes[literal.Suffix]; ok {
+			number = fmt.Sprintf("((%s)%s)", cType, number)
+		}
+
+		codegen.Out(number)
 	case parser.TYPE_FLOAT:
-		codegen.Out(literal.Value)
+		number := strconv.FormatFloat(literal.Float, 'g', -1, 64)
+
+		if !strings.ContainsAny(number, ".e") {
+			number += ".0"
+		}
+
+		if literal.Suffix == "f32" {
+			number += "f"
+		}
+
+		codegen.Out(number)
 	case parser.TYPE_STRING:
-		codegen.Out(literal.Value)
+		codegen.Out(quote(literal.Literal))
 	case parser.TYPE_BOOL:
-		codegen.Out(literal.Value)
+		codegen.Out(literal.Literal)
+	case parser.TYPE_FUNCTION:
+		codegen.report(span, "function values are only supported in declarations")
+	case parser.TYPE_STRUCT:
+		// Compound literal with designated initializers
+		codegen.Out(fmt.Sprintf("(%s){", mangle(literal.Struct.Name)))
+
+		for index, field := range literal.Struct.Fields {
+			if index > 0 {
+				codegen.Out(", ")
+			}
+
+			codegen.Out(fmt.Sprintf(".%s = ", mangle(field.Name)))
+			codegen.PrintExpression(field.Value)
+		}
+
+		codegen.Out("}")
 	case parser.TYPE_UNDEFINED:
 		codegen.Out("NULL")
 	}
 }
 
+// quote returns a C string literal, non-ASCII text is written as UTF-8 byte escapes
+func quote(text string) string {
+	builder := strings.Builder{}
+	builder.WriteByte('"')
+
+	for index := 0; index < len(text); index++ {
+		c := text[index]
+
+		switch c {
+		case '"', '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(c)
+		case '\n':
+			builder.WriteString("\\n")
+		case '\t':
+			builder.WriteString("\\t")
+		case '\r':
+			builder.WriteString("\\r")
+		default:
+			if c < 0x20 || c >= 0x7F {
+				// Octal escapes never swallow the characters which follow them
+				fmt.Fprintf(&builder, "\\%03o", c)
+				continue
+			}
+
+			builder.WriteByte(c)
+		}
+	}
+
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
 func (codegen *Codegen) PrintFunctionCall(functionCall *parser.AST_FunctionCall) {
-	codegen.Out(functionCall.Name)
+	codegen.Out(mangle(functionCall.Name))
 	codegen.Out("(")
 	for index, param := range functionCall.Params {
 		codegen.PrintExpression(param)
 
 		if index < len(functionCall.Params)-1 {
-			codegen.Out(",")
+			codegen.Out(", ")
 		}
 	}
 	codegen.Out(")")
@@ -0,0 +1,218 @@
+package codegen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+	"github.com/milansav/Castle/semantic"
+	"github.com/milansav/Castle/typecheck"
+)
+
+func generate(t *testing.T, input string) Codegen {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, diagnostics := mainParser.Start()
+
+	if len(diagnostics) != 0 {
+		t.Fatalf("parser.Start reported %d diagnostics", len(diagnostics))
+	}
+
+	codegen := Create(program)
+	codegen.Start()
+
+	return codegen
+}
+
+func TestCodegenProgram(t *testing.T) {
+	input := "const add = (a, b) => a + b;\nconst x = 255u8;\nconst s = \"a\\\"b\\n\";\nprintf(\"%d\", add(x, 1.5e3f32));"
+
+	codegen := generate(t, input)
+
+	if len(codegen.Diagnostics) != 0 {
+		t.Errorf("codegen.Start reported %d diagnostics", len(codegen.Diagnostics))
+	}
+
+	expected := []string{
+		"int add(int a, int b);",
+		"return a + b;",
+		"uint8_t x;",
+		"x = ((uint8_t)255);",
+		"s = \"a\\\"b\\n\";",
+		"printf(\"%d\", add(x, 1500.0f));",
+		"int main(void) {",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
+
+func TestCodegenUserMain(t *testing.T) {
+	codegen := generate(t, "const main = (argc, argv) => {\n return 0;\n};\nprintf(\"x\");")
+
+	if !strings.Contains(codegen.OutBuffer, "int main(int argc, char** argv) {") {
+		t.Errorf("codegen.Start did not emit the user main\n%s", codegen.OutBuffer)
+	}
+
+	if len(codegen.Diagnostics) != 1 {
+		t.Errorf("codegen.Start reported %d diagnostics, expected 1 for the statement outside of main", len(codegen.Diagnostics))
+	}
+}
+
+func TestCodegenGlobals(t *testing.T) {
+	codegen := generate(t, "const x = 1;\nconst f = () => x + 1;\nprintf(\"%d\", f());")
+
+	global, assignment := strings.Index(codegen.OutBuffer, "int x;\n"), strings.Index(codegen.OutBuffer, "    x = 1;\n")
+
+	if global == -1 || assignment == -1 || global > strings.Index(codegen.OutBuffer, "int f(void) {") {
+		t.Errorf("codegen.Start did not emit x as a global assigned in main\n%s", codegen.OutBuffer)
+	}
+}
+
+func TestCodegenUnary(t *testing.T) {
+	codegen := generate(t, "printf(\"%d\", - -1);")
+
+	if !strings.Contains(codegen.OutBuffer, "printf(\"%d\", -(-1));") {
+		t.Errorf("codegen.Start did not separate the unary operators\n%s", codegen.OutBuffer)
+	}
+}
+
+func TestCodegenLineDirectives(t *testing.T) {
+	mainLexer := lexer.Create("const f = (a: int) =>\n a + 1;\nif (a < 0) {\n printf(\"a\");\n} elseif (a == 0) {\n printf(\"b\");\n}")
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, _ := mainParser.Start()
+
+	codegen := Create(program)
+	codegen.File = "f.cst"
+	codegen.Start()
+
+	expected := []string{
+		"#line 2 \"f.cst\"\n    return a + 1;",
+		"}\n#line 5 \"f.cst\"\n    else if (a == 0) {",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
+
+func TestCodegenMangle(t *testing.T) {
+	if mangle("int") != "castle_int" || mangle("main🤪") != "main_u1F92A" || mangle("snake_case") != "snake_case" {
+		t.Error("mangle produced an incorrect C identifier")
+	}
+}
+
+func TestCodegenIfChain(t *testing.T) {
+	codegen := generate(t, "const a = 1;\nif (a < 0) {\n printf(\"a\");\n} elseif (a == 0) {\n printf(\"b\");\n} else {\n printf(\"c\");\n}")
+
+	expected := []string{
+		"if (a < 0) {",
+		"} else if (a == 0) {",
+		"} else {",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
+
+func TestCodegenLoops(t *testing.T) {
+	codegen := generate(t, "val a = 0;\nwhile (a < 3) {\n a += 1;\n break;\n}\nfor (x of 10) {\n continue;\n}")
+
+	expected := []string{
+		"while (a < 3) {",
+		"break;",
+		"for (int x = 0, castle_end0 = 10; x < castle_end0; x++) {",
+		"continue;",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
+
+func TestCodegenStructs(t *testing.T) {
+	codegen := generate(t, "const p = Point { x: 1, f: g };\nstruct Point {\n x: int,\n f: (int) => bool,\n}\nprintf(\"%d\", p.x);")
+
+	expected := []string{
+		"typedef struct Point {\n    int x;\n    bool (*f)(int);\n} Point;\n",
+		"(Point){.x = 1, .f = g}",
+		"p.x",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+
+	if strings.Index(codegen.OutBuffer, "typedef") > strings.Index(codegen.OutBuffer, "main") {
+		t.Errorf("codegen.Start did not emit the struct before main\n%s", codegen.OutBuffer)
+	}
+}
+
+func TestCodegenInterfaces(t *testing.T) {
+	codegen := generate(t, "interface Shape {\n area: (int) => float,\n}\nstruct Square {\n area: (int) => float,\n}")
+
+	expected := []string{
+		"typedef struct Square Square;",
+		"typedef struct castle_Shape_vtable {\n    double (*area)(void*, int);\n} castle_Shape_vtable;\n",
+		"typedef struct Shape {\n    void* self;\n    const castle_Shape_vtable* vtable;\n} Shape;\n",
+		"static double castle_Shape_area(Shape value, int p0) {\n    return value.vtable->area(value.self, p0);\n}",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
+
+func TestCodegenInterfaceValues(t *testing.T) {
+	input := "interface Shape {\n area: () => float,\n}\nstruct Square {\n side: float,\n area: () => float,\n}\nconst four = () => 4.0;\nconst show = (s: Shape) => printf(\"%f\", s.area());\nshow(Square { side: 2.0, area: four });"
+
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, _ := mainParser.Start()
+
+	analyzer := semantic.Create(program)
+	analyzer.Start()
+
+	checker := typecheck.Create(program, analyzer.Bindings)
+
+	if diagnostics := checker.Start(); len(diagnostics) != 0 {
+		t.Fatalf("Checker.Start reported %v", diagnostics)
+	}
+
+	codegen := Create(program)
+	codegen.Start()
+
+	expected := []string{
+		"static const castle_Shape_vtable castle_Square_Shape_vtable = {\n    castle_Square_Shape_area,\n};",
+		"static Shape castle_Square_as_Shape(Square value) {",
+		"show(castle_Square_as_Shape((Square){.side = 2.0, .area = four}));",
+		"printf(\"%f\", castle_Shape_area(s));",
+	}
+
+	for _, element := range expected {
+		if !strings.Contains(codegen.OutBuffer, element) {
+			t.Errorf("codegen.Start output does not contain %s\n%s", element, codegen.OutBuffer)
+		}
+	}
+}
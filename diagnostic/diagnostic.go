@@ -0,0 +1,136 @@
+package diagnostic
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SV_ERROR Severity = iota
+	SV_WARNING
+	SV_NOTE
+)
+
+var SeverityLabels = map[Severity]string{
+	SV_ERROR:   "error",
+	SV_WARNING: "warning",
+	SV_NOTE:    "note",
+}
+
+// Diagnostic codes, grouped by the phase that reports them
+const (
+	// Lexer
+	CODE_UNTERMINATED_COMMENT = "L0001"
+	CODE_UNTERMINATED_STRING  = "L0002"
+	CODE_INVALID_ESCAPE       = "L0003"
+	CODE_INVALID_NUMBER       = "L0004"
+
+	// Parser
+	CODE_UNEXPECTED_SYMBOL     = "P0001"
+	CODE_EXPECTED_EXPRESSION   = "P0002"
+	CODE_MACRO_NOT_IMPLEMENTED = "P0003"
+	CODE_EXPECTED_TYPE         = "P0004"
+	CODE_INVALID_ASSIGNMENT    = "P0005"
+	CODE_EXPECTED_BLOCK        = "P0006"
+
+	// Semantic analysis
+	CODE_UNDEFINED_NAME = "S0001"
+	CODE_DUPLICATE_NAME = "S0002"
+	CODE_SHADOWED_NAME  = "S0003"
+	CODE_ASSIGN_CONST   = "S0004"
+	CODE_UNINITIALIZED  = "S0005"
+	CODE_OUTSIDE_LOOP   = "S0006"
+
+	// Type checking
+	CODE_TYPE_MISMATCH   = "T0001"
+	CODE_INVALID_OPERAND = "T0002"
+	CODE_NOT_CALLABLE    = "T0003"
+	CODE_ARGUMENT_COUNT  = "T0004"
+	CODE_UNKNOWN_TYPE    = "T0005"
+	CODE_UNKNOWN_FIELD   = "T0006"
+	CODE_MISSING_FIELD   = "T0007"
+	CODE_NOT_SATISFIED   = "T0008"
+
+	// Code generation
+	CODE_UNSUPPORTED = "C0001"
+
+	// Building
+	CODE_C_COMPILER = "B0001"
+)
+
+type Position struct {
+	Offset int
+	Row    int
+	Column int
+}
+
+type Span struct {
+	Start Position
+	End   Position
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Span     Span
+	Notes    []string
+}
+
+func (position Position) String() string {
+	return fmt.Sprintf("%d:%d", position.Row, position.Column)
+}
+
+func Error(code string, span Span, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity: SV_ERROR,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+func Warning(code string, span Span, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{
+		Severity: SV_WARNING,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+func (diagnostic Diagnostic) WithNote(format string, args ...interface{}) Diagnostic {
+	diagnostic.Notes = append(diagnostic.Notes, fmt.Sprintf(format, args...))
+	return diagnostic
+}
+
+// Format renders the diagnostic as "file:row:column: severity[code]: message"
+// followed by one indented line per note
+func (diagnostic Diagnostic) Format(file string) string {
+	builder := strings.Builder{}
+
+	fmt.Fprintf(&builder, "%s:%s: %s[%s]: %s",
+		file,
+		diagnostic.Span.Start,
+		SeverityLabels[diagnostic.Severity],
+		diagnostic.Code,
+		diagnostic.Message)
+
+	for _, note := range diagnostic.Notes {
+		fmt.Fprintf(&builder, "\n    note: %s", note)
+	}
+
+	return builder.String()
+}
+
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == SV_ERROR {
+			return true
+		}
+	}
+
+	return false
+}
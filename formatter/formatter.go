@@ -0,0 +1,348 @@
+// Package formatter prints Castle source in its canonical layout: four
+// spaces per block level, one statement per line, spaces around binary
+// operators and after commas, and at most one blank line between statements.
+// Comments are kept.
+package formatter
+
+import (
+	"strings"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+const indentation = "    "
+
+// BracesKind tells how the contents of a pair of braces are laid out
+type BracesKind int
+
+const (
+	BR_BLOCK   BracesKind = iota // Statements, one per line
+	BR_FIELDS                    // Fields of a struct or interface declaration, one per line
+	BR_LITERAL                   // Fields of a struct literal, on the line of the braces
+)
+
+type braces struct {
+	kind BracesKind
+	// Open parentheses when the braces were opened, commas in nested parentheses do not separate fields
+	parens int
+}
+
+type formatter struct {
+	lexemes  []lexer.Lexeme
+	current  int
+	out      strings.Builder
+	depth    int
+	previous lexer.Lexeme
+	// The lexeme before previous
+	beforePrevious lexer.Lexeme
+	// Open braces, innermost last
+	braces []braces
+	parens int
+	// The previous lexeme is a unary - or !
+	previousUnary bool
+	// Row of the end of the last lexeme or comment written
+	lastRow int
+	// Line breaks to write before the next lexeme, at most 2 for one blank line
+	newlines int
+	// Nothing has been written on the current line yet
+	lineStart bool
+}
+
+// Format returns the formatted source. Sources with syntax errors are not
+// formatted, the diagnostics are returned instead.
+func Format(source string) (string, []diagnostic.Diagnostic) {
+	checkLexer := lexer.Create(source)
+	checkLexer.Start()
+
+	checkParser := parser.Create(checkLexer)
+	_, diagnostics := checkParser.Start()
+
+	diagnostics = append(checkLexer.Diagnostics, diagnostics...)
+
+	if diagnostic.HasErrors(diagnostics) {
+		return source, diagnostics
+	}
+
+	mainLexer := lexer.Create(source)
+	mainLexer.KeepComments = true
+	mainLexer.Start()
+
+	formatter := &formatter{
+		lexemes:        mainLexer.Lexemes,
+		previous:       lexer.Lexeme{Type: lexer.LT_NONE},
+		beforePrevious: lexer.Lexeme{Type: lexer.LT_NONE},
+		lineStart:      true,
+	}
+
+	formatter.format()
+
+	return formatter.out.String(), diagnostics
+}
+
+func (formatter *formatter) format() {
+	for ; formatter.current < len(formatter.lexemes); formatter.current++ {
+		lexeme := formatter.lexemes[formatter.current]
+
+		switch lexeme.Type {
+		case lexer.LT_END:
+			// An empty source stays empty
+			if formatter.out.Len() > 0 {
+				formatter.out.WriteString("\n")
+			}
+
+			return
+		case lexer.LT_COMMENT:
+			formatter.comment(lexeme)
+			continue
+		case lexer.LT_LCURLY:
+			formatter.openBlock(lexeme)
+		case lexer.LT_RCURLY:
+			formatter.closeBlock(lexeme)
+		case lexer.LT_SEMICOLON:
+			formatter.write(lexeme, false)
+			formatter.breakLine(1)
+		case lexer.LT_COMMA:
+			formatter.write(lexeme, false)
+
+			if top := formatter.top(); top.kind == BR_FIELDS && top.parens == formatter.parens {
+				formatter.breakLine(1)
+			}
+		default:
+			formatter.write(lexeme, formatter.spaceBefore(lexeme))
+
+			if lexeme.Type == lexer.LT_LPAREN {
+				formatter.parens++
+			} else if lexeme.Type == lexer.LT_RPAREN {
+				formatter.parens--
+			}
+		}
+
+		formatter.previousUnary = formatter.isUnary(lexeme)
+		formatter.beforePrevious = formatter.previous
+		formatter.previous = lexeme
+	}
+}
+
+// next returns the next lexeme that is not a comment
+func (formatter *formatter) next() lexer.Lexeme {
+	for index := formatter.current + 1; index < len(formatter.lexemes); index++ {
+		if formatter.lexemes[index].Type != lexer.LT_COMMENT {
+			return formatter.lexemes[index]
+		}
+	}
+
+	return lexer.Lexeme{Type: lexer.LT_END}
+}
+
+// breakLine requests count line breaks before the next lexeme
+func (formatter *formatter) breakLine(count int) {
+	if count > formatter.newlines {
+		formatter.newlines = count
+	}
+}
+
+// write writes the lexeme after the pending line breaks, blank lines of the
+// source between statements are kept
+func (formatter *formatter) write(lexeme lexer.Lexeme, space bool) {
+	if formatter.newlines > 0 {
+		if formatter.newlines == 1 && lexeme.Row-formatter.lastRow > 1 && formatter.previous.Type != lexer.LT_LCURLY && lexeme.Type != lexer.LT_RCURLY {
+			formatter.newlines = 2
+		}
+
+		if formatter.out.Len() > 0 {
+			formatter.out.WriteString(strings.Repeat("\n", formatter.newlines))
+		}
+
+		formatter.newlines = 0
+		formatter.lineStart = true
+	}
+
+	if formatter.lineStart {
+		formatter.out.WriteString(strings.Repeat(indentation, formatter.depth))
+	} else if space {
+		formatter.out.WriteString(" ")
+	}
+
+	formatter.out.WriteString(lexeme.Label)
+	formatter.lineStart = false
+	formatter.lastRow = lexeme.End.Row
+}
+
+// top returns the innermost open braces, a block at the top level
+func (formatter *formatter) top() braces {
+	if len(formatter.braces) == 0 {
+		return braces{kind: BR_BLOCK}
+	}
+
+	return formatter.braces[len(formatter.braces)-1]
+}
+
+// bracesKind tells what the braces opened after the previous lexeme contain,
+// "struct Name {" and "interface Name {" declare fields and "Name {" starts a
+// struct literal
+func (formatter *formatter) bracesKind() BracesKind {
+	if formatter.previous.Type != lexer.LT_IDENTIFIER {
+		return BR_BLOCK
+	}
+
+	if formatter.beforePrevious.Type == lexer.LT_STRUCT || formatter.beforePrevious.Type == lexer.LT_INTERFACE {
+		return BR_FIELDS
+	}
+
+	return BR_LITERAL
+}
+
+func (formatter *formatter) openBlock(lexeme lexer.Lexeme) {
+	formatter.write(lexeme, true)
+
+	kind := formatter.bracesKind()
+
+	if kind == BR_LITERAL {
+		formatter.braces = append(formatter.braces, braces{kind, formatter.parens})
+		return
+	}
+
+	// Empty blocks stay on one line
+	if formatter.next().Type == lexer.LT_RCURLY && !formatter.commentFollows() {
+		formatter.current++
+		formatter.write(formatter.lexemes[formatter.current], false)
+		formatter.afterBlock()
+
+		return
+	}
+
+	formatter.braces = append(formatter.braces, braces{kind, formatter.parens})
+	formatter.depth++
+	formatter.breakLine(1)
+}
+
+func (formatter *formatter) closeBlock(lexeme lexer.Lexeme) {
+	kind := formatter.top().kind
+
+	if len(formatter.braces) > 0 {
+		formatter.braces = formatter.braces[:len(formatter.braces)-1]
+	}
+
+	if kind == BR_LITERAL {
+		formatter.write(lexeme, formatter.previous.Type != lexer.LT_LCURLY)
+		return
+	}
+
+	formatter.depth--
+	formatter.breakLine(1)
+	formatter.write(lexeme, false)
+	formatter.afterBlock()
+}
+
+// afterBlock ends the line after a block unless the statement goes on, as in "};" or "} else {"
+func (formatter *formatter) afterBlock() {
+	switch formatter.next().Type {
+	case lexer.LT_SEMICOLON, lexer.LT_RPAREN, lexer.LT_COMMA, lexer.LT_ELSE, lexer.LT_ELSEIF:
+	default:
+		formatter.breakLine(1)
+	}
+}
+
+func (formatter *formatter) commentFollows() bool {
+	return formatter.current+1 < len(formatter.lexemes) && formatter.lexemes[formatter.current+1].Type == lexer.LT_COMMENT
+}
+
+// comment keeps comments that follow code on the same line there, others get their own line
+func (formatter *formatter) comment(lexeme lexer.Lexeme) {
+	trailing := formatter.out.Len() > 0 && lexeme.Row == formatter.lastRow
+
+	if trailing {
+		pending := formatter.newlines
+		formatter.newlines = 0
+		formatter.write(lexeme, true)
+		formatter.breakLine(pending)
+	} else {
+		formatter.breakLine(1)
+		formatter.write(lexeme, false)
+	}
+
+	if !trailing || strings.HasPrefix(lexeme.Label, "//") {
+		formatter.breakLine(1)
+	}
+}
+
+var binaryOperators = map[lexer.LexemeType]bool{
+	lexer.LT_PLUS:     true,
+	lexer.LT_MINUS:    true,
+	lexer.LT_MULTIPLY: true,
+	lexer.LT_DIVIDE:   true,
+	lexer.LT_MODULO:   true,
+	lexer.LT_POWER:    true,
+	lexer.LT_EQUALS:   true,
+
+	lexer.LT_PLUS_EQUALS:     true,
+	lexer.LT_MINUS_EQUALS:    true,
+	lexer.LT_MULTIPLY_EQUALS: true,
+	lexer.LT_DIVIDE_EQUALS:   true,
+	lexer.LT_MODULO_EQUALS:   true,
+
+	lexer.LT_COMPARE:  true,
+	lexer.LT_GEQ:      true,
+	lexer.LT_LEQ:      true,
+	lexer.LT_NEQ:      true,
+	lexer.LT_EQ:       true,
+	lexer.LT_AND:      true,
+	lexer.LT_OR:       true,
+	lexer.LT_NAND:     true,
+	lexer.LT_NOR:      true,
+	lexer.LT_XOR:      true,
+	lexer.LT_XAND:     true,
+	lexer.LT_XNOR:     true,
+	lexer.LT_XNAND:    true,
+	lexer.LT_LCHEVRON: true,
+	lexer.LT_RCHEVRON: true,
+	lexer.LT_LAMBDA:   true,
+}
+
+// startsOperand reports whether an operand comes after the lexeme, which
+// makes a following - or ! a unary operator
+func startsOperand(lexeme lexer.Lexeme) bool {
+	if binaryOperators[lexeme.Type] {
+		return true
+	}
+
+	switch lexeme.Type {
+	case lexer.LT_NONE, lexer.LT_LPAREN, lexer.LT_LBRACKET, lexer.LT_LCURLY, lexer.LT_COMMA,
+		lexer.LT_SEMICOLON, lexer.LT_COLON, lexer.LT_RETURN, lexer.LT_BANG, lexer.LT_OF:
+		return true
+	}
+
+	return false
+}
+
+func (formatter *formatter) isUnary(lexeme lexer.Lexeme) bool {
+	return (lexeme.Type == lexer.LT_MINUS || lexeme.Type == lexer.LT_BANG) && startsOperand(formatter.previous)
+}
+
+func (formatter *formatter) spaceBefore(lexeme lexer.Lexeme) bool {
+	previous := formatter.previous
+
+	switch lexeme.Type {
+	case lexer.LT_RPAREN, lexer.LT_RBRACKET, lexer.LT_COMMA, lexer.LT_SEMICOLON, lexer.LT_PERIOD, lexer.LT_COLON:
+		return false
+	case lexer.LT_LPAREN, lexer.LT_LBRACKET:
+		// Calls and indexing
+		if previous.Type == lexer.LT_IDENTIFIER || previous.Type == lexer.LT_RPAREN || previous.Type == lexer.LT_RBRACKET {
+			return false
+		}
+	}
+
+	switch previous.Type {
+	case lexer.LT_LPAREN, lexer.LT_LBRACKET, lexer.LT_PERIOD, lexer.LT_MACRO, lexer.LT_NONE:
+		return false
+	case lexer.LT_MINUS, lexer.LT_BANG:
+		// No space after a unary operator, unless it would make - - into --
+		if formatter.previousUnary && !(previous.Type == lexer.LT_MINUS && lexeme.Type == lexer.LT_MINUS) {
+			return false
+		}
+	}
+
+	return true
+}
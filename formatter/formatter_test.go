@@ -0,0 +1,78 @@
+package formatter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/milansav/Castle/diagnostic"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", ""},
+		{"1+2*3;", "1 + 2 * 3;\n"},
+		{"val a=1;a+=-1;a%=a*2;", "val a = 1;\na += -1;\na %= a * 2;\n"},
+		{"const a=1;const b=-a;", "const a = 1;\nconst b = -a;\n"},
+		{"const f = (a,b)=>{return a and !b;};", "const f = (a, b) => {\n    return a and !b;\n};\n"},
+		{"const f = (a) =>\n    g(a , 1);", "const f = (a) => g(a, 1);\n"},
+		{"const f = () => {\n};", "const f = () => {};\n"},
+		{"if (a>2){\nf(a);}", "if (a > 2) {\n    f(a);\n}\n"},
+		{"a;\n\n\n\nb;", "a;\n\nb;\n"},
+		{"// first\na; // trailing\n/* block */ b;", "// first\na; // trailing\n/* block */\nb;\n"},
+		{"const f = () => {\n  // only a comment\n};", "const f = () => {\n    // only a comment\n};\n"},
+		{"struct P{x:int,f:(int,int)=>int}", "struct P {\n    x: int,\n    f: (int, int) => int\n}\n"},
+		{"const a = - -1;\nconst b = !!c;", "const a = - -1;\nconst b = !!c;\n"},
+		{"interface S{area:()=>float}", "interface S {\n    area: () => float\n}\n"},
+		{"const p=P{x:1,y:Q{}};", "const p = P { x: 1, y: Q {} };\n"},
+	}
+
+	for _, test := range tests {
+		output, diagnostics := Format(test.input)
+
+		if len(diagnostics) != 0 {
+			t.Errorf("formatter.Format reported %d diagnostics for %q", len(diagnostics), test.input)
+			continue
+		}
+
+		if output != test.expected {
+			t.Errorf("formatter.Format output is incorrect for %q.\nExpected %q\ngot      %q", test.input, test.expected, output)
+		}
+	}
+}
+
+func TestFormatIdempotent(t *testing.T) {
+	files, _ := filepath.Glob("../examples/*.cst")
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Errorf("formatter could not read %s: %s", file, err)
+			continue
+		}
+
+		once, diagnostics := Format(string(contents))
+
+		if diagnostic.HasErrors(diagnostics) {
+			continue
+		}
+
+		if twice, _ := Format(once); twice != once {
+			t.Errorf("formatter.Format is not idempotent for %s.\nFirst\n%s\nsecond\n%s", file, once, twice)
+		}
+	}
+}
+
+func TestFormatSyntaxError(t *testing.T) {
+	input := "const a = ;"
+
+	output, diagnostics := Format(input)
+
+	if !diagnostic.HasErrors(diagnostics) || output != input {
+		t.Errorf("formatter.Format formatted a source with syntax errors %q", output)
+	}
+}
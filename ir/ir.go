@@ -1,5 +1,12 @@
 package ir
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/milansav/Castle/lexer"
+)
+
 type InstructionType uint
 
 const (
@@ -9,4 +16,118 @@ const (
 	IT_JMP                              // Go to scope
 	IT_BE_CALL                          // Call backend api - print, etc
 	IT_DEF_STACK                        // Define stack
+	IT_BRANCH                           // Go to label when the operand is false
+	IT_LABEL                            // Jump target
+	IT_RETURN                           // Leave the function
 )
+
+var InstructionTypeLabels = map[InstructionType]string{
+	IT_NOOP:      "noop",
+	IT_SCOPE:     "scope",
+	IT_END:       "end",
+	IT_JMP:       "jmp",
+	IT_BE_CALL:   "call",
+	IT_DEF_STACK: "def",
+	IT_BRANCH:    "branch",
+	IT_LABEL:     "label",
+	IT_RETURN:    "ret",
+}
+
+// Instruction operands are atoms: names, temporaries (%0, %1, ...) or literals.
+// Name is the scope, label, call or stack slot the instruction refers to.
+type Instruction struct {
+	Type     InstructionType
+	Name     string
+	Operator lexer.LexemeType
+	Operands []string
+	// Stack slot receiving the result of IT_BE_CALL, empty when unused
+	Result string
+}
+
+var operatorLabels = map[lexer.LexemeType]string{
+	lexer.LT_PLUS:     "+",
+	lexer.LT_MINUS:    "-",
+	lexer.LT_MULTIPLY: "*",
+	lexer.LT_DIVIDE:   "/",
+	lexer.LT_MODULO:   "%",
+	lexer.LT_POWER:    "^",
+	lexer.LT_EQ:       "==",
+	lexer.LT_NEQ:      "!=",
+	lexer.LT_GEQ:      ">=",
+	lexer.LT_LEQ:      "<=",
+	lexer.LT_LCHEVRON: "<",
+	lexer.LT_RCHEVRON: ">",
+	lexer.LT_BANG:     "!",
+	lexer.LT_AND:      "and",
+	lexer.LT_OR:       "or",
+	lexer.LT_NAND:     "nand",
+	lexer.LT_NOR:      "nor",
+	lexer.LT_XOR:      "xor",
+	lexer.LT_XAND:     "xand",
+	lexer.LT_XNOR:     "xnor",
+	lexer.LT_XNAND:    "xnand",
+}
+
+func (instruction Instruction) String() string {
+	label := InstructionTypeLabels[instruction.Type]
+
+	switch instruction.Type {
+	case IT_SCOPE:
+		return fmt.Sprintf("%s %s(%s)", label, instruction.Name, strings.Join(instruction.Operands, ", "))
+	case IT_DEF_STACK:
+		switch len(instruction.Operands) {
+		case 1:
+			if instruction.Operator != lexer.LT_NONE {
+				return fmt.Sprintf("%s %s = %s%s", label, instruction.Name, operatorLabels[instruction.Operator], instruction.Operands[0])
+			}
+
+			return fmt.Sprintf("%s %s = %s", label, instruction.Name, instruction.Operands[0])
+		case 2:
+			return fmt.Sprintf("%s %s = %s %s %s", label, instruction.Name, instruction.Operands[0], operatorLabels[instruction.Operator], instruction.Operands[1])
+		}
+
+		return fmt.Sprintf("%s %s", label, instruction.Name)
+	case IT_BE_CALL:
+		call := fmt.Sprintf("%s %s(%s)", label, instruction.Name, strings.Join(instruction.Operands, ", "))
+
+		if instruction.Result != "" {
+			return fmt.Sprintf("%s = %s", instruction.Result, call)
+		}
+
+		return call
+	case IT_BRANCH:
+		return fmt.Sprintf("%s %s, %s", label, instruction.Operands[0], instruction.Name)
+	case IT_JMP, IT_LABEL:
+		return fmt.Sprintf("%s %s", label, instruction.Name)
+	case IT_RETURN:
+		if len(instruction.Operands) > 0 {
+			return fmt.Sprintf("%s %s", label, instruction.Operands[0])
+		}
+
+		return label
+	default:
+		return label
+	}
+}
+
+// Format prints one instruction per line, indented by scope
+func Format(instructions []Instruction) string {
+	builder := strings.Builder{}
+	depth := 0
+
+	for _, instruction := range instructions {
+		if instruction.Type == IT_END {
+			depth--
+		}
+
+		builder.WriteString(strings.Repeat("  ", depth))
+		builder.WriteString(instruction.String())
+		builder.WriteString("\n")
+
+		if instruction.Type == IT_SCOPE {
+			depth++
+		}
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,62 @@
+package ir
+
+import (
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+func parse(t *testing.T, input string) *parser.AST_Program {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, diagnostics := mainParser.Start()
+
+	if len(diagnostics) != 0 {
+		t.Fatalf("parser.Start reported %d diagnostics", len(diagnostics))
+	}
+
+	return program
+}
+
+func TestLower(t *testing.T) {
+	program := parse(t, "const f = (x) => x * 2;\nconst a = f(1 + 2);\nif (a > 4) {\n printf(\"big\");\n}")
+
+	expected := `scope program()
+  scope f(x)
+    def %0 = x * 2
+    ret %0
+  end
+  def %1 = 1 + 2
+  %2 = call f(%1)
+  def a = %2
+  def %3 = a > 4
+  branch %3, L0
+  %4 = call printf("big")
+  label L0
+end
+`
+
+	if output := Format(Lower(program)); output != expected {
+		t.Errorf("ir.Lower output is incorrect. Expected\n%s\ngot\n%s", expected, output)
+	}
+}
+
+func TestLowerMemberAccess(t *testing.T) {
+	program := parse(t, "const a = mk().x;\nconst b = P { x: 4.0 }.x;\nshape.describe(\"a\");")
+
+	expected := `scope program()
+  %0 = call mk()
+  def a = %0.x
+  def %1 = P { x: 4.0 }
+  def b = %1.x
+  %2 = call shape.describe("a")
+end
+`
+
+	if output := Format(Lower(program)); output != expected {
+		t.Errorf("ir.Lower output is incorrect. Expected\n%s\ngot\n%s", expected, output)
+	}
+}
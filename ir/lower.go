@@ -0,0 +1,330 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+type lowering struct {
+	instructions []Instruction
+	temporaries  int
+	labels       int
+	// Innermost loop last, the targets of break and continue
+	loops []loop
+}
+
+type loop struct {
+	next string
+	end  string
+}
+
+// Lower translates the program into a flat list of instructions. Expressions
+// are split into IT_DEF_STACK instructions on temporaries, one per operator.
+func Lower(program *parser.AST_Program) []Instruction {
+	lowering := &lowering{}
+
+	lowering.emit(Instruction{Type: IT_SCOPE, Name: "program"})
+
+	for _, statement := range program.Statements {
+		lowering.statement(statement)
+	}
+
+	lowering.emit(Instruction{Type: IT_END})
+
+	return lowering.instructions
+}
+
+func (lowering *lowering) emit(instruction Instruction) {
+	lowering.instructions = append(lowering.instructions, instruction)
+}
+
+func (lowering *lowering) temporary() string {
+	name := fmt.Sprintf("%%%d", lowering.temporaries)
+	lowering.temporaries++
+
+	return name
+}
+
+func (lowering *lowering) label() string {
+	name := fmt.Sprintf("L%d", lowering.labels)
+	lowering.labels++
+
+	return name
+}
+
+func (lowering *lowering) function(name string, function *parser.AST_Function) {
+	lowering.emit(Instruction{Type: IT_SCOPE, Name: name, Operands: function.ParamNames()})
+
+	if body := function.ExpressionBody(); body != nil {
+		value := lowering.expression(body)
+		lowering.emit(Instruction{Type: IT_RETURN, Operands: []string{value}})
+	} else {
+		lowering.statement(function.Statement)
+	}
+
+	lowering.emit(Instruction{Type: IT_END})
+}
+
+func (lowering *lowering) statement(statement *parser.AST_Statement) {
+	switch statement.SType {
+	case parser.ST_STATEMENT_ARRAY:
+		for _, element := range statement.Statements {
+			lowering.statement(element)
+		}
+	case parser.ST_STATEMENT:
+		lowering.statement(statement.Statement)
+	case parser.ST_EXPRESSION:
+		lowering.expression(statement.Expression)
+	case parser.ST_FUNCTION:
+		lowering.function(statement.Function.Name, statement.Function)
+	case parser.ST_DECLARATION:
+		value := statement.Declaration.Value
+
+		if value == nil {
+			lowering.emit(Instruction{Type: IT_DEF_STACK, Name: statement.Declaration.Name})
+			return
+		}
+
+		if value.EType == parser.ET_VALUE && value.Value.Type == parser.TYPE_FUNCTION {
+			lowering.function(statement.Declaration.Name, value.Value.Function)
+			return
+		}
+
+		lowering.emit(Instruction{
+			Type:     IT_DEF_STACK,
+			Name:     statement.Declaration.Name,
+			Operator: lexer.LT_NONE,
+			Operands: []string{lowering.expression(value)},
+		})
+	case parser.ST_IF:
+		end := lowering.label()
+
+		for branch := statement.If; branch != nil; branch = branch.Else {
+			// Each branch that fails goes to the next one, the last one to the end
+			next := end
+
+			if branch.Condition != nil {
+				if branch.Else != nil {
+					next = lowering.label()
+				}
+
+				condition := lowering.expression(branch.Condition)
+				lowering.emit(Instruction{Type: IT_BRANCH, Name: next, Operands: []string{condition}})
+			}
+
+			for _, element := range branch.Statements {
+				lowering.statement(element)
+			}
+
+			if next != end {
+				lowering.emit(Instruction{Type: IT_JMP, Name: end})
+				lowering.emit(Instruction{Type: IT_LABEL, Name: next})
+			}
+		}
+
+		lowering.emit(Instruction{Type: IT_LABEL, Name: end})
+	case parser.ST_WHILE:
+		start, end := lowering.label(), lowering.label()
+
+		lowering.emit(Instruction{Type: IT_LABEL, Name: start})
+		condition := lowering.expression(statement.Loop.Condition)
+		lowering.emit(Instruction{Type: IT_BRANCH, Name: end, Operands: []string{condition}})
+
+		lowering.body(loop{next: start, end: end}, statement.Loop.Statements)
+
+		lowering.emit(Instruction{Type: IT_JMP, Name: start})
+		lowering.emit(Instruction{Type: IT_LABEL, Name: end})
+	case parser.ST_FOR:
+		lowering.loop(statement.Loop)
+	case parser.ST_BREAK:
+		lowering.emit(Instruction{Type: IT_JMP, Name: lowering.loops[len(lowering.loops)-1].end})
+	case parser.ST_CONTINUE:
+		lowering.emit(Instruction{Type: IT_JMP, Name: lowering.loops[len(lowering.loops)-1].next})
+	case parser.ST_RETURN:
+		lowering.emit(Instruction{Type: IT_RETURN, Operands: []string{lowering.expression(statement.Expression)}})
+	case parser.ST_ASSIGNMENT:
+		assignment := statement.Assignment
+		value := lowering.expression(assignment.Value)
+		target := lowering.expression(assignment.Target)
+
+		if operator, ok := parser.CompoundOperators[assignment.Operator]; ok {
+			// x += v is x = x + v
+			lowering.emit(Instruction{Type: IT_DEF_STACK, Name: target, Operator: operator, Operands: []string{target, value}})
+			return
+		}
+
+		lowering.emit(Instruction{Type: IT_DEF_STACK, Name: target, Operator: lexer.LT_NONE, Operands: []string{value}})
+	}
+}
+
+// body lowers the statements of a loop, break and continue inside go to its labels
+func (lowering *lowering) body(target loop, statements []*parser.AST_Statement) {
+	lowering.loops = append(lowering.loops, target)
+
+	for _, element := range statements {
+		lowering.statement(element)
+	}
+
+	lowering.loops = lowering.loops[:len(lowering.loops)-1]
+}
+
+// loop lowers a for loop to an index counting up to the length of the
+// collection, the backend provides len and at for every iterable type
+func (lowering *lowering) loop(statement *parser.AST_Loop) {
+	collection := lowering.expression(statement.Collection)
+	length, index := lowering.temporary(), lowering.temporary()
+	start, next, end := lowering.label(), lowering.label(), lowering.label()
+
+	lowering.emit(Instruction{Type: IT_BE_CALL, Name: "len", Operands: []string{collection}, Result: length})
+	lowering.emit(Instruction{Type: IT_DEF_STACK, Name: index, Operator: lexer.LT_NONE, Operands: []string{"0"}})
+
+	lowering.emit(Instruction{Type: IT_LABEL, Name: start})
+	condition := lowering.temporary()
+	lowering.emit(Instruction{Type: IT_DEF_STACK, Name: condition, Operator: lexer.LT_LCHEVRON, Operands: []string{index, length}})
+	lowering.emit(Instruction{Type: IT_BRANCH, Name: end, Operands: []string{condition}})
+	lowering.emit(Instruction{Type: IT_BE_CALL, Name: "at", Operands: []string{collection, index}, Result: statement.Variable.Name})
+
+	lowering.body(loop{next: next, end: end}, statement.Statements)
+
+	lowering.emit(Instruction{Type: IT_LABEL, Name: next})
+	lowering.emit(Instruction{Type: IT_DEF_STACK, Name: index, Operator: lexer.LT_PLUS, Operands: []string{index, "1"}})
+	lowering.emit(Instruction{Type: IT_JMP, Name: start})
+	lowering.emit(Instruction{Type: IT_LABEL, Name: end})
+}
+
+// expression lowers the expression and returns the atom holding its value
+func (lowering *lowering) expression(expression *parser.AST_Expression) string {
+	switch expression.EType {
+	case parser.ET_BINARY:
+		lhs := lowering.expression(expression.Lhs)
+		rhs := lowering.expression(expression.Rhs)
+		result := lowering.temporary()
+
+		lowering.emit(Instruction{Type: IT_DEF_STACK, Name: result, Operator: expression.Operator, Operands: []string{lhs, rhs}})
+
+		return result
+	case parser.ET_UNARY:
+		rhs := lowering.expression(expression.Rhs)
+		result := lowering.temporary()
+
+		lowering.emit(Instruction{Type: IT_DEF_STACK, Name: result, Operator: expression.Operator, Operands: []string{rhs}})
+
+		return result
+	case parser.ET_VALUE:
+		if expression.Value.Struct != nil {
+			return lowering.structLiteral(expression.Value.Struct)
+		}
+
+		return literal(expression.Value)
+	case parser.ET_IDENTIFIER:
+		return expression.Identifier
+	case parser.ET_GROUP:
+		return lowering.expression(expression.Lhs)
+	case parser.ET_EXPRESSION_ARRAY:
+		lowering.expression(expression.Lhs)
+		return lowering.expression(expression.Rhs)
+	case parser.ET_FUNCTION_CALL:
+		operands := make([]string, 0)
+
+		for _, param := range expression.FunctionCall.Params {
+			operands = append(operands, lowering.expression(param))
+		}
+
+		result := lowering.temporary()
+
+		lowering.emit(Instruction{Type: IT_BE_CALL, Name: expression.FunctionCall.Name, Operands: operands, Result: result})
+
+		return result
+	case parser.ET_MEMBER_ACCESS:
+		return lowering.memberAccess(expression)
+	}
+
+	return "undefined"
+}
+
+// structLiteral lowers the field values and defines a temporary holding the struct
+func (lowering *lowering) structLiteral(literal *parser.AST_Struct) string {
+	fields := make([]string, 0, len(literal.Fields))
+
+	for _, field := range literal.Fields {
+		fields = append(fields, field.Name+": "+lowering.expression(field.Value))
+	}
+
+	result := lowering.temporary()
+
+	lowering.emit(Instruction{
+		Type:     IT_DEF_STACK,
+		Name:     result,
+		Operator: lexer.LT_NONE,
+		Operands: []string{literal.Name + " { " + strings.Join(fields, ", ") + " }"},
+	})
+
+	return result
+}
+
+func literal(value *parser.AST_Value) string {
+	switch value.Type {
+	case parser.TYPE_STRING:
+		return strconv.Quote(value.Literal)
+	case parser.TYPE_NUMBER:
+		return strconv.FormatUint(value.Integer, 10) + value.Suffix
+	case parser.TYPE_FLOAT:
+		number := strconv.FormatFloat(value.Float, 'g', -1, 64)
+
+		// Keep 4.0 from looking like an int
+		if !strings.ContainsAny(number, ".e") {
+			number += ".0"
+		}
+
+		return number + value.Suffix
+	case parser.TYPE_UNDEFINED:
+		return "undefined"
+	default:
+		return value.Literal
+	}
+}
+
+// memberAccess lowers the root of a.b.c and returns the atom of the access,
+// member calls are backend calls on the member, see parser.memberAccess for
+// the shape of the tree
+func (lowering *lowering) memberAccess(expression *parser.AST_Expression) string {
+	root := *expression
+	root.EType = parser.ET_IDENTIFIER
+
+	if expression.FunctionCall != nil {
+		root.EType = parser.ET_FUNCTION_CALL
+	} else if expression.Value != nil {
+		root.EType = parser.ET_VALUE
+	} else if expression.Lhs != nil {
+		root.EType = parser.ET_GROUP
+	}
+
+	result := lowering.expression(&root)
+
+	for link := expression.Rhs; link != nil; link = link.Rhs {
+		member := link.Lhs
+
+		if member.FunctionCall == nil {
+			result += "." + member.Identifier
+			continue
+		}
+
+		operands := make([]string, 0, len(member.FunctionCall.Params))
+
+		for _, param := range member.FunctionCall.Params {
+			operands = append(operands, lowering.expression(param))
+		}
+
+		call := lowering.temporary()
+
+		lowering.emit(Instruction{Type: IT_BE_CALL, Name: result + "." + member.FunctionCall.Name, Operands: operands, Result: call})
+
+		result = call
+	}
+
+	return result
+}
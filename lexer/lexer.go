@@ -1,23 +1,45 @@
 package lexer
 
 import (
+	"strconv"
+	"strings"
 	"unicode"
 	"unicode/utf8"
+
+	"github.com/milansav/Castle/diagnostic"
 )
 
 type Lexer struct {
 	Lexemes     []Lexeme
+	Diagnostics []diagnostic.Diagnostic
+	// Emit comments as LT_COMMENT lexemes instead of skipping them, the parser does not accept them
+	KeepComments bool
+	// Attach whitespace and comments to the following lexeme as Trivia, the
+	// source can then be reprinted with Text. Takes precedence over KeepComments.
+	KeepTrivia  bool
+	trivia      []Trivia
 	source      string
 	currentStep int
 	row         int
 	column      int
 }
 
+// Row and Column are 1-based, Column counts runes rather than bytes.
+// Offset is the byte offset of the first byte of the lexeme in the source.
+// Label is always the source text of the lexeme, Value holds the decoded
+// contents of string literals and the digits of number literals without
+// their type Suffix.
 type Lexeme struct {
 	Label  string
+	Value  string
+	Suffix string
 	Type   LexemeType
 	Row    int
 	Column int
+	Offset int
+	End    diagnostic.Position
+	// Whitespace and comments before the lexeme, only with Lexer.KeepTrivia
+	Trivia []Trivia
 }
 
 type LexemeType int
@@ -33,6 +55,13 @@ const (
 
 	LT_EQUALS
 
+	// Compound assignment
+	LT_PLUS_EQUALS
+	LT_MINUS_EQUALS
+	LT_MULTIPLY_EQUALS
+	LT_DIVIDE_EQUALS
+	LT_MODULO_EQUALS
+
 	//Logical
 	LT_COMPARE
 	LT_GEQ
@@ -79,6 +108,10 @@ const (
 	LT_STRUCT
 	LT_OF
 	LT_RETURN
+	LT_WHILE
+	LT_FOR
+	LT_BREAK
+	LT_CONTINUE
 
 	//Misc operators
 	LT_LAMBDA
@@ -89,6 +122,9 @@ const (
 	LT_COMMA
 	LT_PERIOD
 
+	// Only emitted with Lexer.KeepComments
+	LT_COMMENT
+
 	LT_NONE
 	LT_UNKNOWN
 	LT_END
@@ -105,6 +141,13 @@ var LexemeTypeLabels = map[LexemeType]string{
 
 	LT_EQUALS: "LT_EQUALS",
 
+	// Compound assignment
+	LT_PLUS_EQUALS:     "LT_PLUS_EQUALS",
+	LT_MINUS_EQUALS:    "LT_MINUS_EQUALS",
+	LT_MULTIPLY_EQUALS: "LT_MULTIPLY_EQUALS",
+	LT_DIVIDE_EQUALS:   "LT_DIVIDE_EQUALS",
+	LT_MODULO_EQUALS:   "LT_MODULO_EQUALS",
+
 	//Logical
 	LT_COMPARE: "LT_COMPARE",
 	LT_GEQ:     "LT_GEQ",
@@ -150,6 +193,10 @@ var LexemeTypeLabels = map[LexemeType]string{
 	LT_STRUCT:    "LT_STRUCT",
 	LT_OF:        "LT_OF",
 	LT_RETURN:    "LT_RETURN",
+	LT_WHILE:     "LT_WHILE",
+	LT_FOR:       "LT_FOR",
+	LT_BREAK:     "LT_BREAK",
+	LT_CONTINUE:  "LT_CONTINUE",
 
 	//Misc operators
 	LT_LAMBDA:    "LT_LAMBDA",
@@ -160,6 +207,8 @@ var LexemeTypeLabels = map[LexemeType]string{
 	LT_COMMA:  "LT_COMMA",
 	LT_PERIOD: "LT_PERIOD",
 
+	LT_COMMENT: "LT_COMMENT",
+
 	LT_NONE:    "LT_NONE",
 	LT_UNKNOWN: "LT_UNKNOWN",
 	LT_END:     "LT_END",
@@ -175,6 +224,10 @@ var keywords = map[string]LexemeType{
 	"struct":    LT_STRUCT,
 	"of":        LT_OF,
 	"return":    LT_RETURN,
+	"while":     LT_WHILE,
+	"for":       LT_FOR,
+	"break":     LT_BREAK,
+	"continue":  LT_CONTINUE,
 	"true":      LT_LITERAL_BOOL,
 	"false":     LT_LITERAL_BOOL,
 
@@ -194,6 +247,15 @@ func Create(source string) Lexer {
 		Lexemes:     make([]Lexeme, 0),
 		source:      source,
 		currentStep: 0,
+		row:         1,
+		column:      1,
+	}
+}
+
+func (lexeme Lexeme) Span() diagnostic.Span {
+	return diagnostic.Span{
+		Start: diagnostic.Position{Offset: lexeme.Offset, Row: lexeme.Row, Column: lexeme.Column},
+		End:   lexeme.End,
 	}
 }
 
@@ -201,42 +263,82 @@ func (lexer *Lexer) Start() {
 	for canStep(lexer) {
 
 		c := currentRune(lexer)
+		start := position(lexer)
 
 		if c == '/' {
 			switch nextRune(lexer) {
 			case '/':
-				lineComment(lexer)
-				//lexer.Lexemes = append(lexer.Lexemes, lexeme)
+				lexeme := lineComment(lexer)
+
+				if lexer.KeepTrivia {
+					addTrivia(lexer, TV_LINE_COMMENT, start)
+				} else if lexer.KeepComments {
+					emit(lexer, lexeme, start)
+				}
+
+				continue
+			case '*':
+				lexeme := blockComment(lexer)
+
+				if lexer.KeepTrivia {
+					addTrivia(lexer, TV_BLOCK_COMMENT, start)
+				} else if lexer.KeepComments {
+					emit(lexer, lexeme, start)
+				}
+
 				continue
-				//case '*':
-				//	lexeme := blockComment(lexer)
-				//	lexer.Lexemes = append(lexer.Lexemes, lexeme)
-				//	continue
 			}
 		}
 
 		if unicode.IsSpace(c) {
-			whitespace(lexer)
+			if lexer.KeepTrivia {
+				whitespaceTrivia(lexer)
+			} else {
+				whitespace(lexer)
+			}
+
 			continue
 		} else if unicode.IsLetter(c) {
 			lexeme := identifier(lexer)
-			lexer.Lexemes = append(lexer.Lexemes, lexeme)
+			emit(lexer, lexeme, start)
 			continue
 		} else if unicode.IsDigit(c) {
 			lexeme := number(lexer)
-			lexer.Lexemes = append(lexer.Lexemes, lexeme)
+			emit(lexer, lexeme, start)
 			continue
 		} else {
 			lexeme := other(lexer)
-			lexer.Lexemes = append(lexer.Lexemes, lexeme)
+			emit(lexer, lexeme, start)
 		}
 
 	}
 
-	lexer.Lexemes = append(lexer.Lexemes, Lexeme{Type: LT_END})
+	emit(lexer, Lexeme{Type: LT_END}, position(lexer))
+}
+
+// emit stores the lexeme, positioned from start up to the current position
+func emit(lexer *Lexer, lexeme Lexeme, start diagnostic.Position) {
+	lexeme.Row = start.Row
+	lexeme.Column = start.Column
+	lexeme.Offset = start.Offset
+	lexeme.End = position(lexer)
+	lexeme.Trivia = lexer.trivia
+	lexer.trivia = nil
+
+	lexer.Lexemes = append(lexer.Lexemes, lexeme)
+}
+
+func position(lexer *Lexer) diagnostic.Position {
+	return diagnostic.Position{
+		Offset: lexer.currentStep,
+		Row:    lexer.row,
+		Column: lexer.column,
+	}
 }
 
 func lineComment(lexer *Lexer) Lexeme {
+	start := lexer.currentStep
+
 	for {
 		if currentRune(lexer) != '\n' && canStep(lexer) {
 			step(lexer)
@@ -245,19 +347,63 @@ func lineComment(lexer *Lexer) Lexeme {
 		break
 	}
 
-	return Lexeme{}
+	return Lexeme{Type: LT_COMMENT, Label: lexer.source[start:lexer.currentStep]}
 }
 
+// blockComment skips a /* */ comment, comments may be nested
 func blockComment(lexer *Lexer) Lexeme {
+	start := position(lexer)
+	lexeme := Lexeme{Type: LT_COMMENT}
+
+	// Opening /*
+	step(lexer)
+	step(lexer)
+
+	depth := 1
+
+	for canStep(lexer) {
+		if currentRune(lexer) == '/' && nextRune(lexer) == '*' {
+			depth++
+			step(lexer)
+			step(lexer)
+			continue
+		}
+
+		if currentRune(lexer) == '*' && nextRune(lexer) == '/' {
+			depth--
+			step(lexer)
+			step(lexer)
+
+			if depth == 0 {
+				lexeme.Label = lexer.source[start.Offset:lexer.currentStep]
+				return lexeme
+			}
+
+			continue
+		}
+
+		step(lexer)
+	}
 
-	return Lexeme{}
+	opening := diagnostic.Span{Start: start, End: start}
+	opening.End.Offset += 2
+	opening.End.Column += 2
+
+	report(lexer, diagnostic.Error(
+		diagnostic.CODE_UNTERMINATED_COMMENT,
+		opening,
+		"unterminated block comment"))
+
+	lexeme.Label = lexer.source[start.Offset:lexer.currentStep]
+
+	return lexeme
 }
 
 func identifier(lexer *Lexer) Lexeme {
 
 	start := lexer.currentStep
 
-	for unicode.IsLetter(currentRune(lexer)) || unicode.IsDigit(currentRune(lexer)) || unicode.IsSymbol(currentRune(lexer)) {
+	for isIdentifierRune(currentRune(lexer)) {
 		step(lexer)
 	}
 
@@ -274,6 +420,12 @@ func identifier(lexer *Lexer) Lexeme {
 	return lexeme
 }
 
+// Identifiers may contain letters, digits and non-ASCII symbols such as emoji,
+// ASCII symbols like + or < are operators
+func isIdentifierRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || (c > unicode.MaxASCII && unicode.IsSymbol(c))
+}
+
 func getKeyword(lexeme Lexeme) Lexeme {
 	if _, ok := keywords[lexeme.Label]; ok {
 		lexeme.Type = keywords[lexeme.Label]
@@ -282,32 +434,89 @@ func getKeyword(lexeme Lexeme) Lexeme {
 	return lexeme
 }
 
+// number lexes decimal, hexadecimal (0x), binary (0b) and octal (0o) literals with
+// optional "_" digit separators, fraction, exponent and type suffix (e.g. 255u8, 1.5e-3f32)
 func number(lexer *Lexer) Lexeme {
 
-	isFloat := false
-
-	start := lexer.currentStep
+	start := position(lexer)
 
 	numberType := LT_LITERAL_NUMBER
 
-	c := currentRune(lexer)
+	base := 10
+
+	if currentRune(lexer) == '0' {
+		switch nextRune(lexer) {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+	}
+
+	if base != 10 {
+		step(lexer)
+		step(lexer)
 
-	for unicode.IsDigit(c) || (c == '.' && !isFloat) {
-		if c == '.' {
-			isFloat = true
+		// Digits invalid for the base are consumed as well and reported by validateNumber
+		for isHexDigit(currentRune(lexer)) || currentRune(lexer) == '_' {
+			step(lexer)
+		}
+	} else {
+		decimalDigits(lexer)
+
+		if currentRune(lexer) == '.' && isDecimalDigit(nextRune(lexer)) {
 			numberType = LT_LITERAL_FLOAT
+			step(lexer)
+			decimalDigits(lexer)
 		}
+
+		if c := currentRune(lexer); (c == 'e' || c == 'E') && (isDecimalDigit(nextRune(lexer)) || nextRune(lexer) == '+' || nextRune(lexer) == '-') {
+			numberType = LT_LITERAL_FLOAT
+			step(lexer)
+
+			if currentRune(lexer) == '+' || currentRune(lexer) == '-' {
+				step(lexer)
+			}
+
+			decimalDigits(lexer)
+		}
+	}
+
+	valueEnd := lexer.currentStep
+
+	for unicode.IsLetter(currentRune(lexer)) || unicode.IsDigit(currentRune(lexer)) {
 		step(lexer)
-		c = currentRune(lexer)
 	}
 
-	end := lexer.currentStep
+	lexeme := Lexeme{
+		Label:  lexer.source[start.Offset:lexer.currentStep],
+		Value:  lexer.source[start.Offset:valueEnd],
+		Suffix: lexer.source[valueEnd:lexer.currentStep],
+		Type:   numberType,
+	}
 
-	lexeme := Lexeme{Label: lexer.source[start:end], Type: numberType}
+	if suffixType, ok := numberSuffixes[lexeme.Suffix]; ok && suffixType == LT_LITERAL_FLOAT {
+		lexeme.Type = LT_LITERAL_FLOAT
+	}
+
+	if err := validateNumber(lexeme, base); err != nil {
+		report(lexer, diagnostic.Error(
+			diagnostic.CODE_INVALID_NUMBER,
+			diagnostic.Span{Start: start, End: position(lexer)},
+			"invalid number literal %s: %s", lexeme.Label, err))
+	}
 
 	return lexeme
 }
 
+func decimalDigits(lexer *Lexer) {
+	for isDecimalDigit(currentRune(lexer)) || currentRune(lexer) == '_' {
+		step(lexer)
+	}
+}
+
 func other(lexer *Lexer) Lexeme {
 	target := currentRune(lexer)
 
@@ -328,14 +537,19 @@ func other(lexer *Lexer) Lexeme {
 		}
 	case '+':
 		lexeme.Type = LT_PLUS
+		compoundAssignment(lexer, &lexeme, LT_PLUS_EQUALS)
 	case '-':
 		lexeme.Type = LT_MINUS
+		compoundAssignment(lexer, &lexeme, LT_MINUS_EQUALS)
 	case '*':
 		lexeme.Type = LT_MULTIPLY
+		compoundAssignment(lexer, &lexeme, LT_MULTIPLY_EQUALS)
 	case '/':
 		lexeme.Type = LT_DIVIDE
+		compoundAssignment(lexer, &lexeme, LT_DIVIDE_EQUALS)
 	case '%':
 		lexeme.Type = LT_MODULO
+		compoundAssignment(lexer, &lexeme, LT_MODULO_EQUALS)
 	case '^':
 		lexeme.Type = LT_POWER
 	case '(':
@@ -392,29 +606,179 @@ func other(lexer *Lexer) Lexeme {
 
 		lexeme.Type = LT_UNKNOWN
 	case '"':
-		lexeme.Type = LT_LITERAL_STRING
+		return quotedString(lexer)
+	case '`':
+		return rawString(lexer)
+	}
 
-		start := lexer.currentStep
+	step(lexer)
+
+	end := lexer.currentStep
+	lexeme.Label = lexer.source[start:end]
+
+	return lexeme
+}
+
+// compoundAssignment turns an operator followed by "=" into its assignment, e.g. "+="
+func compoundAssignment(lexer *Lexer, lexeme *Lexeme, assignment LexemeType) {
+	if nextRune(lexer) == '=' {
+		lexeme.Type = assignment
 		step(lexer)
-		for {
-			if nextRune(lexer) == '"' {
-				step(lexer)
-				break
-			}
+	}
+}
+
+// quotedString lexes a "..." string literal and decodes its escape sequences
+func quotedString(lexer *Lexer) Lexeme {
+	start := position(lexer)
+	value := strings.Builder{}
+
+	// Opening quote
+	step(lexer)
+
+	for {
+		c := currentRune(lexer)
+
+		if !canStep(lexer) || c == '\n' {
+			report(lexer, diagnostic.Error(
+				diagnostic.CODE_UNTERMINATED_STRING,
+				diagnostic.Span{Start: start, End: position(lexer)},
+				"unterminated string literal").WithNote("use a `raw string` for text spanning multiple lines"))
+			break
+		}
+
+		if c == '"' {
 			step(lexer)
+			break
 		}
 
-		end := lexer.currentStep
+		if c == '\\' {
+			escape(lexer, &value)
+			continue
+		}
 
-		lexeme.Label = lexer.source[start:end]
+		value.WriteRune(c)
+		step(lexer)
+	}
+
+	return Lexeme{
+		Label: lexer.source[start.Offset:lexer.currentStep],
+		Value: value.String(),
+		Type:  LT_LITERAL_STRING,
 	}
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+}
 
+// escape decodes a single escape sequence starting at the backslash
+func escape(lexer *Lexer, value *strings.Builder) {
+	start := position(lexer)
+
+	// Backslash
 	step(lexer)
 
-	end := lexer.currentStep
-	lexeme.Label = lexer.source[start:end]
+	c := currentRune(lexer)
 
-	return lexeme
+	if decoded, ok := escapes[c]; ok {
+		value.WriteRune(decoded)
+		step(lexer)
+		return
+	}
+
+	if c == 'u' && nextRune(lexer) == '{' {
+		step(lexer)
+		step(lexer)
+
+		digits := lexer.currentStep
+
+		for isHexDigit(currentRune(lexer)) {
+			step(lexer)
+		}
+
+		hex := lexer.source[digits:lexer.currentStep]
+		closed := currentRune(lexer) == '}'
+
+		if closed {
+			step(lexer)
+		}
+
+		code, err := strconv.ParseUint(hex, 16, 32)
+
+		if !closed || err != nil || len(hex) > 6 || !utf8.ValidRune(rune(code)) {
+			report(lexer, diagnostic.Error(
+				diagnostic.CODE_INVALID_ESCAPE,
+				diagnostic.Span{Start: start, End: position(lexer)},
+				"invalid unicode escape %s", lexer.source[start.Offset:lexer.currentStep]).WithNote("expected \\u{XXXX} with 1 to 6 hex digits"))
+			return
+		}
+
+		value.WriteRune(rune(code))
+		return
+	}
+
+	if c == '\n' || !canStep(lexer) {
+		// Reported as an unterminated string by the caller
+		return
+	}
+
+	step(lexer)
+
+	report(lexer, diagnostic.Error(
+		diagnostic.CODE_INVALID_ESCAPE,
+		diagnostic.Span{Start: start, End: position(lexer)},
+		"unknown escape sequence \\%c", c))
+
+	value.WriteRune(c)
+}
+
+// rawString lexes a `...` string literal, which may span multiple lines and has no escapes
+func rawString(lexer *Lexer) Lexeme {
+	start := position(lexer)
+
+	// Opening backtick
+	step(lexer)
+
+	contents := lexer.currentStep
+
+	for canStep(lexer) && currentRune(lexer) != '`' {
+		step(lexer)
+	}
+
+	value := lexer.source[contents:lexer.currentStep]
+
+	if canStep(lexer) {
+		step(lexer)
+	} else {
+		report(lexer, diagnostic.Error(
+			diagnostic.CODE_UNTERMINATED_STRING,
+			diagnostic.Span{Start: start, End: start},
+			"unterminated raw string literal"))
+	}
+
+	return Lexeme{
+		Label: lexer.source[start.Offset:lexer.currentStep],
+		Value: value,
+		Type:  LT_LITERAL_STRING,
+	}
+}
+
+func isHexDigit(c rune) bool {
+	return isDecimalDigit(c) || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func isDecimalDigit(c rune) bool {
+	return '0' <= c && c <= '9'
+}
+
+func report(lexer *Lexer, diagnostic diagnostic.Diagnostic) {
+	lexer.Diagnostics = append(lexer.Diagnostics, diagnostic)
 }
 
 func whitespace(lexer *Lexer) {
@@ -428,12 +792,27 @@ func canStep(lexer *Lexer) bool {
 }
 
 func step(lexer *Lexer) {
-	_, size := utf8.DecodeRuneInString(lexer.source[lexer.currentStep:])
+	if !canStep(lexer) {
+		return
+	}
+
+	stepped, size := utf8.DecodeRuneInString(lexer.source[lexer.currentStep:])
 
 	lexer.currentStep += size
+
+	if stepped == '\n' {
+		lexer.row++
+		lexer.column = 1
+	} else {
+		lexer.column++
+	}
 }
 
 func currentRune(lexer *Lexer) rune {
+	if !canStep(lexer) {
+		return 0
+	}
+
 	currentRune, _ := utf8.DecodeRuneInString(lexer.source[lexer.currentStep:])
 
 	return currentRune
@@ -441,6 +820,10 @@ func currentRune(lexer *Lexer) rune {
 
 func nextRune(lexer *Lexer) rune {
 	_, size := utf8.DecodeRuneInString(lexer.source[lexer.currentStep:])
+
+	if lexer.currentStep+size >= len(lexer.source) {
+		return 0
+	}
 	nextRune, _ := utf8.DecodeRuneInString(lexer.source[lexer.currentStep+size:])
 
 	return nextRune
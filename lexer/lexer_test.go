@@ -64,3 +64,273 @@ func TestLexerBigNumbers(t *testing.T) {
 	}
 
 }
+
+func TestLexerPositions(t *testing.T) {
+	input := "const a🤪 = 1;\n  b"
+
+	lexer := Create(input)
+
+	lexer.Start()
+
+	expected := []struct {
+		row    int
+		column int
+		offset int
+	}{
+		{1, 1, 0},
+		{1, 7, 6},
+		{1, 10, 12},
+		{1, 12, 14},
+		{1, 13, 15},
+		{2, 3, 19},
+		{2, 4, 20},
+	}
+
+	if len(lexer.Lexemes) != len(expected) {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected %d got %d", len(expected), len(lexer.Lexemes))
+		return
+	}
+
+	for index, element := range lexer.Lexemes {
+		if element.Row != expected[index].row || element.Column != expected[index].column || element.Offset != expected[index].offset {
+			t.Errorf("lexer.Start Lexeme at index %d has position %d:%d (%d), expected %d:%d (%d)",
+				index, element.Row, element.Column, element.Offset,
+				expected[index].row, expected[index].column, expected[index].offset)
+		}
+	}
+
+	if lexer.Lexemes[1].End.Column != 9 || lexer.Lexemes[1].End.Offset != 11 {
+		t.Errorf("lexer.Start Lexeme end is incorrect %d (%d)", lexer.Lexemes[1].End.Column, lexer.Lexemes[1].End.Offset)
+	}
+}
+
+func TestLexerBlockComments(t *testing.T) {
+	input := "1 /* outer /* inner */\n still comment */ + 2"
+	expectedTypes := []LexemeType{LT_LITERAL_NUMBER, LT_PLUS, LT_LITERAL_NUMBER, LT_END}
+
+	lexer := Create(input)
+
+	lexer.Start()
+
+	if len(lexer.Lexemes) != len(expectedTypes) {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected %d got %d", len(expectedTypes), len(lexer.Lexemes))
+		return
+	}
+
+	for index, element := range lexer.Lexemes {
+		if element.Type != expectedTypes[index] {
+			t.Errorf("lexer.Start Lexeme at index %d incorrect type", index)
+		}
+	}
+
+	if lexer.Lexemes[1].Row != 2 || lexer.Lexemes[1].Column != 19 {
+		t.Errorf("lexer.Start Lexeme after comment has position %d:%d", lexer.Lexemes[1].Row, lexer.Lexemes[1].Column)
+	}
+
+	if len(lexer.Diagnostics) != 0 {
+		t.Errorf("lexer.Start reported %d diagnostics", len(lexer.Diagnostics))
+	}
+}
+
+func TestLexerKeepComments(t *testing.T) {
+	input := "a // line\n/* block */ b"
+	expectedLabels := []string{"a", "// line", "/* block */", "b", ""}
+
+	lexer := Create(input)
+	lexer.KeepComments = true
+
+	lexer.Start()
+
+	if len(lexer.Lexemes) != len(expectedLabels) {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected %d got %d", len(expectedLabels), len(lexer.Lexemes))
+		return
+	}
+
+	for index, element := range lexer.Lexemes {
+		if element.Label != expectedLabels[index] {
+			t.Errorf("lexer.Start Lexeme at index %d has label %q, expected %q", index, element.Label, expectedLabels[index])
+		}
+	}
+
+	if lexer.Lexemes[1].Type != LT_COMMENT || lexer.Lexemes[2].Row != 2 || lexer.Lexemes[2].End.Column != 12 {
+		t.Errorf("lexer.Start comment lexemes are incorrect %+v", lexer.Lexemes[1:3])
+	}
+}
+
+func TestLexerUnterminatedBlockComment(t *testing.T) {
+	input := "1\n  /* a /* b */"
+
+	lexer := Create(input)
+
+	lexer.Start()
+
+	if len(lexer.Diagnostics) != 1 {
+		t.Errorf("lexer.Start reported %d diagnostics, expected 1", len(lexer.Diagnostics))
+		return
+	}
+
+	if lexer.Diagnostics[0].Span.Start.String() != "2:3" {
+		t.Errorf("lexer.Start unterminated comment reported at %s", lexer.Diagnostics[0].Span.Start)
+	}
+
+	if len(lexer.Lexemes) != 2 {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected 2 got %d", len(lexer.Lexemes))
+	}
+}
+
+func TestLexerStrings(t *testing.T) {
+	input := "\"a\\\"b\\n\\t\\u{1F92A}\\\\\" `raw \\n\nline`"
+
+	lexer := Create(input)
+
+	lexer.Start()
+
+	if len(lexer.Diagnostics) != 0 {
+		t.Errorf("lexer.Start reported %d diagnostics", len(lexer.Diagnostics))
+	}
+
+	if len(lexer.Lexemes) != 3 {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected 3 got %d", len(lexer.Lexemes))
+		return
+	}
+
+	if lexer.Lexemes[0].Value != "a\"b\n\t🤪\\" {
+		t.Errorf("lexer.Start string value is incorrect %q", lexer.Lexemes[0].Value)
+	}
+
+	if lexer.Lexemes[0].Label != input[:lexer.Lexemes[1].Offset-1] {
+		t.Errorf("lexer.Start string label is incorrect %q", lexer.Lexemes[0].Label)
+	}
+
+	if lexer.Lexemes[1].Value != "raw \\n\nline" || lexer.Lexemes[1].Type != LT_LITERAL_STRING {
+		t.Errorf("lexer.Start raw string value is incorrect %q", lexer.Lexemes[1].Value)
+	}
+
+	if lexer.Lexemes[2].Row != 2 {
+		t.Errorf("lexer.Start row after raw string is incorrect %d", lexer.Lexemes[2].Row)
+	}
+}
+
+func TestLexerStringErrors(t *testing.T) {
+	inputs := map[string]string{
+		"\"abc":           "L0002",
+		"\"abc\nconst":    "L0002",
+		"`abc":            "L0002",
+		"\"a\\qb\"":       "L0003",
+		"\"\\u{110000}\"": "L0003",
+		"\"\\u{41\"":      "L0003",
+	}
+
+	for input, code := range inputs {
+		lexer := Create(input)
+
+		lexer.Start()
+
+		if len(lexer.Diagnostics) != 1 || lexer.Diagnostics[0].Code != code {
+			t.Errorf("lexer.Start diagnostics for %q are incorrect, expected one %s", input, code)
+		}
+	}
+}
+
+func TestLexerNumbers(t *testing.T) {
+	inputs := []struct {
+		input     string
+		lexemType LexemeType
+		integer   uint64
+		float     float64
+		suffix    string
+	}{
+		{"0xFF", LT_LITERAL_NUMBER, 255, 255, ""},
+		{"0b1010", LT_LITERAL_NUMBER, 10, 10, ""},
+		{"0o17", LT_LITERAL_NUMBER, 15, 15, ""},
+		{"1_000_000", LT_LITERAL_NUMBER, 1000000, 1000000, ""},
+		{"1.5e-3", LT_LITERAL_FLOAT, 0, 0.0015, ""},
+		{"2E10", LT_LITERAL_FLOAT, 0, 2e10, ""},
+		{"255u8", LT_LITERAL_NUMBER, 255, 255, "u8"},
+		{"0x7Fi64", LT_LITERAL_NUMBER, 127, 127, "i64"},
+		{"3f32", LT_LITERAL_FLOAT, 0, 3, "f32"},
+		{"0.25f64", LT_LITERAL_FLOAT, 0, 0.25, "f64"},
+	}
+
+	for _, element := range inputs {
+		lexer := Create(element.input)
+
+		lexer.Start()
+
+		if len(lexer.Lexemes) != 2 || len(lexer.Diagnostics) != 0 {
+			t.Errorf("lexer.Start did not produce a single valid lexeme for %s", element.input)
+			continue
+		}
+
+		lexeme := lexer.Lexemes[0]
+		integer, float, err := NumberValue(lexeme)
+
+		if lexeme.Type != element.lexemType || lexeme.Suffix != element.suffix || lexeme.Label != element.input {
+			t.Errorf("lexer.Start lexeme for %s is incorrect: %s %q", element.input, LexemeTypeLabels[lexeme.Type], lexeme.Suffix)
+		}
+
+		if err != nil || integer != element.integer || float != element.float {
+			t.Errorf("NumberValue for %s is incorrect: %d %g %v", element.input, integer, float, err)
+		}
+	}
+}
+
+func TestLexerInvalidNumbers(t *testing.T) {
+	inputs := []string{"0b102", "0x", "0o8", "1__0", "1_", "300u8", "128i8", "1.5u8", "0xFFu7", "12abc", "1e+", "99999999999999999999"}
+
+	for _, input := range inputs {
+		lexer := Create(input)
+
+		lexer.Start()
+
+		if len(lexer.Diagnostics) != 1 || lexer.Diagnostics[0].Code != "L0004" {
+			t.Errorf("lexer.Start did not report an invalid number for %s", input)
+		}
+
+		if len(lexer.Lexemes) != 2 {
+			t.Errorf("lexer.Start split %s into %d lexemes", input, len(lexer.Lexemes)-1)
+		}
+	}
+}
+
+func TestLexerIdentifiers(t *testing.T) {
+	input := "a+c main🤪 snake_case<b"
+	expectedTypes := []LexemeType{LT_IDENTIFIER, LT_PLUS, LT_IDENTIFIER, LT_IDENTIFIER, LT_IDENTIFIER, LT_LCHEVRON, LT_IDENTIFIER, LT_END}
+
+	lexer := Create(input)
+
+	lexer.Start()
+
+	if len(lexer.Lexemes) != len(expectedTypes) {
+		t.Errorf("lexer.Start Lexemes size is incorrect. Expected %d got %d", len(expectedTypes), len(lexer.Lexemes))
+		return
+	}
+
+	for index, element := range lexer.Lexemes {
+		if element.Type != expectedTypes[index] {
+			t.Errorf("lexer.Start Lexeme at index %d incorrect type", index)
+		}
+	}
+}
+
+func TestLexerAssignments(t *testing.T) {
+	input := "x = 1; x += 1; x -= 1; x *= 1; x /= 1; x %= 1; x == 1"
+	expectedTypes := []LexemeType{LT_EQUALS, LT_PLUS_EQUALS, LT_MINUS_EQUALS, LT_MULTIPLY_EQUALS, LT_DIVIDE_EQUALS, LT_MODULO_EQUALS, LT_EQ}
+	lexer := Create(input)
+
+	lexer.Start()
+
+	index := 0
+
+	for _, element := range lexer.Lexemes {
+		if element.Type == LT_IDENTIFIER || element.Type == LT_LITERAL_NUMBER || element.Type == LT_SEMICOLON || element.Type == LT_END {
+			continue
+		}
+
+		if index >= len(expectedTypes) || element.Type != expectedTypes[index] {
+			t.Errorf("lexer.Start Lexeme %q has incorrect type %s", element.Label, LexemeTypeLabels[element.Type])
+		}
+
+		index++
+	}
+}
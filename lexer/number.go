@@ -0,0 +1,149 @@
+package lexer
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Type suffixes of number literals, mapped to the literal type they produce
+var numberSuffixes = map[string]LexemeType{
+	"u8":  LT_LITERAL_NUMBER,
+	"u16": LT_LITERAL_NUMBER,
+	"u32": LT_LITERAL_NUMBER,
+	"u64": LT_LITERAL_NUMBER,
+	"i8":  LT_LITERAL_NUMBER,
+	"i16": LT_LITERAL_NUMBER,
+	"i32": LT_LITERAL_NUMBER,
+	"i64": LT_LITERAL_NUMBER,
+	"f32": LT_LITERAL_FLOAT,
+	"f64": LT_LITERAL_FLOAT,
+}
+
+var baseNames = map[int]string{
+	2:  "binary",
+	8:  "octal",
+	10: "decimal",
+	16: "hexadecimal",
+}
+
+// NumberValue parses a number literal lexeme. Integer literals return their
+// value as integer and float, float literals only as float.
+func NumberValue(lexeme Lexeme) (integer uint64, float float64, err error) {
+	digits := strings.ReplaceAll(lexeme.Value, "_", "")
+
+	if lexeme.Type == LT_LITERAL_FLOAT {
+		bits := 64
+
+		if lexeme.Suffix == "f32" {
+			bits = 32
+		}
+
+		float, err = strconv.ParseFloat(digits, bits)
+
+		return 0, float, err
+	}
+
+	base := 10
+
+	if len(digits) > 1 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+	}
+
+	if base != 10 {
+		digits = digits[2:]
+	}
+
+	bits := 63
+
+	if lexeme.Suffix != "" {
+		bits, _ = strconv.Atoi(lexeme.Suffix[1:])
+
+		if lexeme.Suffix[0] == 'i' {
+			bits--
+		}
+	}
+
+	integer, err = strconv.ParseUint(digits, base, bits)
+
+	return integer, float64(integer), err
+}
+
+func validateNumber(lexeme Lexeme, base int) error {
+	suffixType, ok := numberSuffixes[lexeme.Suffix]
+
+	if lexeme.Suffix != "" && !ok {
+		return fmt.Errorf("unknown type suffix %q", lexeme.Suffix)
+	}
+
+	if lexeme.Suffix != "" && lexeme.Type == LT_LITERAL_FLOAT && suffixType != LT_LITERAL_FLOAT {
+		return fmt.Errorf("integer suffix %q on a float literal", lexeme.Suffix)
+	}
+
+	digits := lexeme.Value
+
+	if base != 10 {
+		digits = digits[2:]
+
+		if strings.Trim(digits, "_") == "" {
+			return fmt.Errorf("%s literal has no digits", baseNames[base])
+		}
+
+		for _, c := range digits {
+			if c != '_' && !isDigitOf(c, base) {
+				return fmt.Errorf("invalid digit %q in %s literal", c, baseNames[base])
+			}
+		}
+	}
+
+	for index, c := range digits {
+		if c != '_' {
+			continue
+		}
+
+		// "_" may only separate two digits, the base prefix counts as a digit
+		followsDigit := (index == 0 && base != 10) || (index > 0 && isDigitOf(rune(digits[index-1]), base))
+		precedesDigit := index+1 < len(digits) && isDigitOf(rune(digits[index+1]), base)
+
+		if !followsDigit || !precedesDigit {
+			return errors.New("\"_\" must separate successive digits")
+		}
+	}
+
+	_, _, err := NumberValue(lexeme)
+
+	if errors.Is(err, strconv.ErrRange) {
+		if lexeme.Suffix != "" {
+			return fmt.Errorf("value out of range for %s", lexeme.Suffix)
+		}
+
+		return errors.New("value out of range")
+	}
+
+	if err != nil {
+		return errors.New("malformed number")
+	}
+
+	return nil
+}
+
+func isDigitOf(c rune, base int) bool {
+	switch base {
+	case 2:
+		return c == '0' || c == '1'
+	case 8:
+		return '0' <= c && c <= '7'
+	case 16:
+		return isHexDigit(c)
+	default:
+		return isDecimalDigit(c)
+	}
+}
@@ -0,0 +1,84 @@
+package lexer
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/milansav/Castle/diagnostic"
+)
+
+type TriviaKind int
+
+const (
+	TV_WHITESPACE    TriviaKind = iota // Spaces and tabs
+	TV_NEWLINE                         // "\n" or "\r\n"
+	TV_LINE_COMMENT                    // "// ..." without the line break
+	TV_BLOCK_COMMENT                   // "/* ... */", possibly nested or unterminated
+)
+
+var TriviaKindLabels = map[TriviaKind]string{
+	TV_WHITESPACE:    "TV_WHITESPACE",
+	TV_NEWLINE:       "TV_NEWLINE",
+	TV_LINE_COMMENT:  "TV_LINE_COMMENT",
+	TV_BLOCK_COMMENT: "TV_BLOCK_COMMENT",
+}
+
+// Trivia is source text between lexemes, only kept with Lexer.KeepTrivia
+type Trivia struct {
+	Kind  TriviaKind
+	Text  string
+	Start diagnostic.Position
+}
+
+// addTrivia keeps the text since start as trivia of the next lexeme
+func addTrivia(lexer *Lexer, kind TriviaKind, start diagnostic.Position) {
+	lexer.trivia = append(lexer.trivia, Trivia{
+		Kind:  kind,
+		Text:  lexer.source[start.Offset:lexer.currentStep],
+		Start: start,
+	})
+}
+
+// whitespaceTrivia splits whitespace into line breaks and runs of other whitespace
+func whitespaceTrivia(lexer *Lexer) {
+	for unicode.IsSpace(currentRune(lexer)) && canStep(lexer) {
+		start := position(lexer)
+
+		if currentRune(lexer) == '\n' || (currentRune(lexer) == '\r' && nextRune(lexer) == '\n') {
+			if currentRune(lexer) == '\r' {
+				step(lexer)
+			}
+
+			step(lexer)
+			addTrivia(lexer, TV_NEWLINE, start)
+
+			continue
+		}
+
+		for unicode.IsSpace(currentRune(lexer)) && currentRune(lexer) != '\n' && !(currentRune(lexer) == '\r' && nextRune(lexer) == '\n') {
+			step(lexer)
+		}
+
+		addTrivia(lexer, TV_WHITESPACE, start)
+	}
+}
+
+// Text returns the source of the lexemes. With KeepTrivia this is exactly the lexed source.
+func Text(lexemes []Lexeme) string {
+	builder := strings.Builder{}
+
+	for _, lexeme := range lexemes {
+		lexeme.WriteText(&builder)
+	}
+
+	return builder.String()
+}
+
+// WriteText writes the leading trivia and the label of the lexeme
+func (lexeme Lexeme) WriteText(builder *strings.Builder) {
+	for _, trivia := range lexeme.Trivia {
+		builder.WriteString(trivia.Text)
+	}
+
+	builder.WriteString(lexeme.Label)
+}
@@ -1,45 +1,395 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/milansav/Castle/astjson"
 	"github.com/milansav/Castle/astprinter"
+	"github.com/milansav/Castle/build"
 	"github.com/milansav/Castle/cli"
+	"github.com/milansav/Castle/codegen"
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/formatter"
+	"github.com/milansav/Castle/ir"
 	"github.com/milansav/Castle/lexer"
 	"github.com/milansav/Castle/parser"
+	"github.com/milansav/Castle/semantic"
+	"github.com/milansav/Castle/typecheck"
 )
 
 func main() {
 
 	settings := cli.ParseArguments()
 
+	failed := false
+
 	for _, file := range settings.Files {
-		contents, err := os.ReadFile(file)
+		if settings.Mode == cli.MODE_FMT {
+			failed = !formatFile(settings, file) || failed
+			continue
+		}
+
+		if !compile(settings, file) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// formatFile prints the formatted file, or checks or rewrites it, and returns false when it failed
+func formatFile(settings cli.CompilerSettings, file string) bool {
+	contents, err := os.ReadFile(file)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return false
+	}
+
+	formatted, diagnostics := formatter.Format(string(contents))
+
+	if !report(file, diagnostics) {
+		return false
+	}
+
+	switch {
+	case settings.Check:
+		if formatted != string(contents) {
+			fmt.Println(file)
+			return false
+		}
+	case settings.Write:
+		if formatted == string(contents) {
+			return true
+		}
+
+		info, err := os.Stat(file)
+
+		if err == nil {
+			err = os.WriteFile(file, []byte(formatted), info.Mode().Perm())
+		}
 
 		if err != nil {
-			_ = fmt.Errorf("error: %s", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return false
+		}
+	default:
+		fmt.Print(formatted)
+	}
+
+	return true
+}
+
+// lastStage returns the furthest stage the command or --emit needs
+func lastStage(settings cli.CompilerSettings) cli.Stage {
+	last := cli.STAGE_TOKENS
+
+	switch settings.Mode {
+	case cli.MODE_PARSE, cli.MODE_CHECK:
+		last = cli.STAGE_AST
+	case cli.MODE_BUILD, cli.MODE_RUN:
+		last = cli.STAGE_C
+	}
+
+	for _, stage := range settings.Emit {
+		if stage > last {
+			last = stage
+		}
+	}
+
+	return last
+}
+
+// compile runs the stages for one file and returns false when it failed. The
+// stages requested with --emit are emitted even when a later stage fails.
+func compile(settings cli.CompilerSettings, file string) bool {
+	contents, err := os.ReadFile(file)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return false
+	}
+
+	outputs := make(map[cli.Stage]string)
+	defer emit(settings, file, outputs)
+
+	last := lastStage(settings)
+
+	mainLexer := lexer.Create(string(contents))
+	mainLexer.Start()
+
+	outputs[cli.STAGE_TOKENS] = formatTokens(mainLexer.Lexemes)
+
+	if tokens, err := astjson.EncodeLexemes(mainLexer.Lexemes); err == nil {
+		outputs[cli.STAGE_TOKENS_JSON] = string(tokens) + "\n"
+	}
+
+	if last <= cli.STAGE_TOKENS_JSON {
+		if len(settings.Emit) == 0 {
+			fmt.Print(outputs[cli.STAGE_TOKENS])
 		}
 
-		fmt.Printf("----------SOURCE----------\n%s\n", string(contents))
+		return report(file, mainLexer.Diagnostics)
+	}
 
-		mainLexer := lexer.Create(string(contents))
-		mainLexer.Start()
+	mainParser := parser.Create(mainLexer)
 
-		// fmt.Println("-----LEXICAL ANALYSIS-----")
+	if settings.TraceParser {
+		mainParser.SetTracer(parser.WriterTracer{Writer: os.Stderr})
+	}
 
-		// for _, element := range mainLexer.Lexemes {
-		// 	fmt.Printf("Label: %s, Type: %s\n", element.Label, lexer.LexemeTypeLabels[element.Type])
-		// }
+	program, parserDiagnostics := mainParser.Start()
 
-		fmt.Println("------SYNTAX ANALYSIS-----")
+	diagnostics := append(mainLexer.Diagnostics, parserDiagnostics...)
 
-		mainParser := parser.Create(mainLexer)
-		program := mainParser.Start()
+	if !report(file, diagnostics) {
+		return false
+	}
 
-		fmt.Println("---ABSTRACT SYNTAX TREE---")
+	outputs[cli.STAGE_AST] = astprinter.SprintAST(program)
+
+	if tree, err := astjson.EncodeProgram(program); err == nil {
+		outputs[cli.STAGE_AST_JSON] = string(tree) + "\n"
+	}
 
+	if settings.Mode == cli.MODE_PARSE && len(settings.Emit) == 0 {
 		astprinter.PrintAST(program)
 	}
+
+	outputs[cli.STAGE_AST_DOT] = astprinter.SprintDOT(program)
+
+	// lex and parse only check the syntax
+	if (settings.Mode == cli.MODE_LEX || settings.Mode == cli.MODE_PARSE) && last <= cli.STAGE_AST_DOT {
+		return true
+	}
+
+	analyzer := semantic.Create(program)
+
+	if !report(file, analyzer.Start()) {
+		return false
+	}
+
+	checker := typecheck.Create(program, analyzer.Bindings)
+
+	if !report(file, checker.Start()) {
+		return false
+	}
+
+	if last <= cli.STAGE_AST_DOT {
+		return true
+	}
+
+	outputs[cli.STAGE_IR] = ir.Format(ir.Lower(program))
+
+	if last == cli.STAGE_IR {
+		return true
+	}
+
+	mainCodegen := codegen.Create(program)
+	mainCodegen.File = file
+	mainCodegen.Start()
+
+	if !report(file, mainCodegen.Diagnostics) {
+		return false
+	}
+
+	outputs[cli.STAGE_C] = mainCodegen.OutBuffer
+
+	if settings.Mode != cli.MODE_BUILD && settings.Mode != cli.MODE_RUN {
+		return true
+	}
+
+	executable, temp, ok := buildExecutable(settings, file, mainCodegen.OutBuffer)
+
+	if !ok {
+		return false
+	}
+
+	if settings.Mode == cli.MODE_RUN {
+		// Emit before exiting, deferred calls do not run on os.Exit
+		emit(settings, file, outputs)
+
+		code := run(executable, settings.ProgramArgs)
+
+		if temp != "" {
+			os.RemoveAll(temp)
+		}
+
+		os.Exit(code)
+	}
+
+	return true
+}
+
+func formatTokens(lexemes []lexer.Lexeme) string {
+	builder := strings.Builder{}
+
+	for _, element := range lexemes {
+		fmt.Fprintf(&builder, "%d:%d Label: %s, Type: %s\n", element.Row, element.Column, element.Label, lexer.LexemeTypeLabels[element.Type])
+	}
+
+	return builder.String()
+}
+
+// emit prints the stages requested with --emit in the requested order, or
+// writes them to <outdir>/<file>.<stage>. A header separates the outputs when
+// there is more than one, so a single stage can be diffed as is.
+func emit(settings cli.CompilerSettings, file string, outputs map[cli.Stage]string) {
+	headers := len(settings.Emit) > 1 || len(settings.Files) > 1
+
+	for _, stage := range settings.Emit {
+		output, ok := outputs[stage]
+
+		if !ok {
+			continue
+		}
+
+		if settings.Outdir != "" {
+			path := filepath.Join(settings.Outdir, baseName(file)+cli.StageExtensions[stage])
+
+			err := os.MkdirAll(settings.Outdir, 0755)
+
+			if err == nil {
+				err = os.WriteFile(path, []byte(output), 0644)
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			}
+
+			continue
+		}
+
+		if headers {
+			fmt.Printf("==> %s (%s) <==\n", file, cli.StageLabels[stage])
+		}
+
+		fmt.Print(output)
+	}
+}
+
+// report prints the diagnostics and returns false when there were errors
+func report(file string, diagnostics []diagnostic.Diagnostic) bool {
+	for _, element := range diagnostics {
+		fmt.Fprintln(os.Stderr, element.Format(file))
+	}
+
+	return !diagnostic.HasErrors(diagnostics)
+}
+
+// writeOutput writes the generated C next to the other outputs in outdir, as <source name>.c
+func writeOutput(outdir string, file string, output string) (string, error) {
+	if outdir == "" {
+		outdir = "."
+	}
+
+	if err := os.MkdirAll(outdir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outdir, baseName(file)+".c")
+
+	return path, os.WriteFile(path, []byte(output), 0644)
+}
+
+func baseName(file string) string {
+	return strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+}
+
+// buildExecutable compiles the generated C with the system C compiler. The C
+// file and the executable go to the output directory, without one the C file
+// goes to a temporary directory and the executable to the working directory,
+// or to the temporary directory too when running. --output overrides the
+// path of the executable. The temporary directory is removed unless the
+// executable is run from it, then it is returned for the caller to remove.
+func buildExecutable(settings cli.CompilerSettings, file string, output string) (string, string, bool) {
+	outdir := settings.Outdir
+	executableDir := outdir
+	temp := ""
+
+	if outdir == "" {
+		var err error
+
+		temp, err = os.MkdirTemp("", "castle-")
+
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return "", "", false
+		}
+
+		outdir = temp
+		executableDir = "."
+
+		if settings.Mode == cli.MODE_RUN {
+			executableDir = temp
+		}
+	}
+
+	executable, ok := compileExecutable(settings, file, output, outdir, executableDir)
+
+	if temp != "" && (!ok || settings.Mode != cli.MODE_RUN) {
+		os.RemoveAll(temp)
+		temp = ""
+	}
+
+	return executable, temp, ok
+}
+
+// compileExecutable writes the C file to outdir and compiles it to an executable in executableDir
+func compileExecutable(settings cli.CompilerSettings, file string, output string, outdir string, executableDir string) (string, bool) {
+	cFile, err := writeOutput(outdir, file, output)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return "", false
+	}
+
+	executable := filepath.Join(executableDir, baseName(file))
+
+	if settings.Output != "" {
+		executable = settings.Output
+	}
+
+	diagnostics, err := build.Compile(file, cFile, executable)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return "", false
+	}
+
+	return executable, report(file, diagnostics)
+}
+
+// run executes the program and returns its exit code
+func run(executable string, args []string) int {
+	if !filepath.IsAbs(executable) && !strings.Contains(executable, string(filepath.Separator)) {
+		executable = "." + string(filepath.Separator) + executable
+	}
+
+	command := exec.Command(executable, args...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+
+	err := command.Run()
+
+	var exitErr *exec.ExitError
+
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		return 1
+	}
+
+	return 0
 }
@@ -0,0 +1,148 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+)
+
+// CSTNode is a node of the concrete syntax tree. Inner nodes are named after
+// the grammar rule that parsed them, leaves hold a single lexeme. Rules that
+// only pass a single inner node through are collapsed into it, lexemes the
+// parser skipped while recovering from an error are kept under a "skipped"
+// node. With lexer.Lexer.KeepTrivia the tree covers every byte of the source.
+type CSTNode struct {
+	Rule     string
+	Lexeme   *lexer.Lexeme
+	Children []*CSTNode
+}
+
+func (node *CSTNode) IsLeaf() bool {
+	return node.Lexeme != nil
+}
+
+// Text returns the source of the node, including the trivia of its lexemes
+func (node *CSTNode) Text() string {
+	builder := strings.Builder{}
+	node.writeText(&builder)
+
+	return builder.String()
+}
+
+func (node *CSTNode) writeText(builder *strings.Builder) {
+	if node.IsLeaf() {
+		node.Lexeme.WriteText(builder)
+		return
+	}
+
+	for _, child := range node.Children {
+		child.writeText(builder)
+	}
+}
+
+// Span returns the span of the lexemes of the node without leading trivia
+func (node *CSTNode) Span() diagnostic.Span {
+	if node.IsLeaf() {
+		return node.Lexeme.Span()
+	}
+
+	if len(node.Children) == 0 {
+		return diagnostic.Span{}
+	}
+
+	return joinSpans(node.Children[0].Span(), node.Children[len(node.Children)-1].Span())
+}
+
+// cstBuilder builds the tree from the trace events of the parser
+type cstBuilder struct {
+	lexemes []lexer.Lexeme
+	// Index of the first lexeme that is not in the tree yet
+	next  int
+	stack []*CSTNode
+	// The tracer set by the user, still gets every event
+	tracer Tracer
+}
+
+func (builder *cstBuilder) top() *CSTNode {
+	return builder.stack[len(builder.stack)-1]
+}
+
+func (builder *cstBuilder) Trace(event TraceEvent) {
+	switch event.Kind {
+	case TK_ENTER:
+		builder.stack = append(builder.stack, &CSTNode{Rule: event.Rule})
+	case TK_EXIT:
+		node := builder.top()
+		builder.stack = builder.stack[:len(builder.stack)-1]
+
+		if len(node.Children) == 1 && !node.Children[0].IsLeaf() {
+			node = node.Children[0]
+		}
+
+		if len(node.Children) > 0 {
+			builder.top().Children = append(builder.top().Children, node)
+		}
+	case TK_ACCEPT:
+		builder.consume(event.Lexeme.Offset)
+
+		// LT_END can be accepted more than once
+		if builder.next < len(builder.lexemes) && builder.lexemes[builder.next].Offset == event.Lexeme.Offset {
+			lexeme := builder.lexemes[builder.next]
+			builder.next++
+
+			builder.top().Children = append(builder.top().Children, &CSTNode{Lexeme: &lexeme})
+		}
+	}
+
+	if builder.tracer != nil {
+		builder.tracer.Trace(event)
+	}
+}
+
+// consume adds the lexemes before offset that were skipped by the parser
+func (builder *cstBuilder) consume(offset int) {
+	skipped := &CSTNode{Rule: "skipped"}
+
+	for builder.next < len(builder.lexemes) && builder.lexemes[builder.next].Offset < offset {
+		lexeme := builder.lexemes[builder.next]
+		skipped.Children = append(skipped.Children, &CSTNode{Lexeme: &lexeme})
+		builder.next++
+	}
+
+	if len(skipped.Children) > 0 {
+		builder.top().Children = append(builder.top().Children, skipped)
+	}
+}
+
+// StartCST parses the program like Start and also returns its concrete syntax tree
+func (parser *Parser) StartCST() (*CSTNode, *AST_Program, []diagnostic.Diagnostic) {
+	root := &CSTNode{Rule: "file"}
+
+	builder := &cstBuilder{
+		lexemes: parser.lexemes,
+		stack:   []*CSTNode{root},
+		tracer:  parser.tracer,
+	}
+
+	parser.tracer = builder
+	defer parser.SetTracer(builder.tracer)
+
+	program, diagnostics := parser.Start()
+
+	if len(root.Children) == 1 && !root.Children[0].IsLeaf() {
+		root = root.Children[0]
+		builder.stack[0] = root
+	}
+
+	// Unparsed lexemes and LT_END, which holds the trivia at the end of the file
+	end := builder.lexemes[len(builder.lexemes)-1]
+	builder.consume(end.Offset)
+
+	if builder.next < len(builder.lexemes) {
+		builder.next++
+		root.Children = append(root.Children, &CSTNode{Lexeme: &end})
+	}
+
+	return root, program, diagnostics
+}
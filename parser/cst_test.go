@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+)
+
+func parseCST(input string) (*CSTNode, *AST_Program) {
+	mainLexer := lexer.Create(input)
+	mainLexer.KeepTrivia = true
+	mainLexer.Start()
+
+	parser := Create(mainLexer)
+	root, program, _ := parser.StartCST()
+
+	return root, program
+}
+
+func TestCSTLossless(t *testing.T) {
+	inputs := []string{
+		"",
+		"  \n// only a comment\r\n",
+		"const a = 1 +  2 ; // trailing\n\n/* block /* nested */ */ a;\n",
+		"const s = \"esc\\n\\u{1F600}\";\tconst r = `raw\nstring`;",
+		"const f = (a, b) => {\n    return a * -b;\n};\n\nf(1, 0x1F_u8);",
+		"if (a) { b ",
+		"const = ; ) } garbage # \"unterminated",
+		"/* unterminated",
+	}
+
+	files, _ := filepath.Glob("../examples/*.cst")
+
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+
+		if err == nil {
+			inputs = append(inputs, string(contents))
+		}
+	}
+
+	for _, input := range inputs {
+		root, _ := parseCST(input)
+
+		if output := root.Text(); output != input {
+			t.Errorf("CSTNode.Text does not reprint the source.\nExpected %q\ngot      %q", input, output)
+		}
+
+		mainLexer := lexer.Create(input)
+		mainLexer.KeepTrivia = true
+		mainLexer.Start()
+
+		if output := lexer.Text(mainLexer.Lexemes); output != input {
+			t.Errorf("lexer.Text does not reprint the source.\nExpected %q\ngot      %q", input, output)
+		}
+	}
+}
+
+func TestCSTStructure(t *testing.T) {
+	root, program := parseCST("const a = 1 + 2;\n")
+
+	if root.Rule != "program" || len(program.Statements) != 1 {
+		t.Errorf("Parser.StartCST root is %q with %d statements", root.Rule, len(program.Statements))
+		return
+	}
+
+	statement := root.Children[0]
+
+	if statement.Rule != "statement" || statement.Text() != "const a = 1 + 2;" {
+		t.Errorf("Parser.StartCST statement is %q %q", statement.Rule, statement.Text())
+	}
+
+	last := root.Children[len(root.Children)-1]
+
+	if !last.IsLeaf() || last.Lexeme.Type != lexer.LT_END || last.Text() != "\n" {
+		t.Errorf("Parser.StartCST does not end with LT_END and the final trivia")
+	}
+
+	var term *CSTNode
+
+	for _, child := range statement.Children {
+		if !child.IsLeaf() {
+			term = child
+		}
+	}
+
+	if term == nil || term.Rule != "term" || len(term.Children) != 3 {
+		t.Errorf("Parser.StartCST did not collapse the expression rules %+v", term)
+	}
+}
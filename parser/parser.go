@@ -1,12 +1,10 @@
 package parser
 
 import (
-	"errors"
 	"fmt"
-	"log"
 
+	"github.com/milansav/Castle/diagnostic"
 	"github.com/milansav/Castle/lexer"
-	"github.com/milansav/Castle/util"
 )
 
 type Parser struct {
@@ -14,6 +12,12 @@ type Parser struct {
 	currentStep   int
 	currentSym    lexer.LexemeType
 	currentLexeme lexer.Lexeme
+	Diagnostics   []diagnostic.Diagnostic
+	// Set after an error until the parser synchronizes, suppresses cascading errors
+	panicking bool
+	tracer    Tracer
+	// Grammar rules currently being parsed, innermost last
+	rules []string
 }
 
 func hasNext(parser *Parser) bool {
@@ -55,8 +59,9 @@ func peek(parser *Parser) lexer.Lexeme {
 func accept(parser *Parser, symbol lexer.LexemeType) bool {
 
 	if parser.currentSym == symbol {
+		accepted := curr(parser)
 		next(parser)
-		fmt.Printf("Accepted symbol: %s\n", lexer.LexemeTypeLabels[parser.currentSym])
+		trace(parser, TK_ACCEPT, symbol, accepted)
 		return true
 	}
 
@@ -65,30 +70,71 @@ func accept(parser *Parser, symbol lexer.LexemeType) bool {
 
 func expect(parser *Parser, symbol lexer.LexemeType) bool {
 
-	expectingMessage := fmt.Sprintf(
-		"%sExpecting symbol: %s%s\n",
-		util.Green,
-		lexer.LexemeTypeLabels[symbol],
-		util.Reset)
-
-	fmt.Println(expectingMessage)
+	trace(parser, TK_EXPECT, symbol, curr(parser))
 
 	if accept(parser, symbol) {
 		return true
 	}
 
-	errorMessage := fmt.Sprintf(
-		"%sUnexpected symbol: %s, expected: %s%s\n",
-		util.Red,
-		lexer.LexemeTypeLabels[parser.currentSym],
-		lexer.LexemeTypeLabels[symbol],
-		util.Reset)
+	report(parser, diagnostic.Error(
+		diagnostic.CODE_UNEXPECTED_SYMBOL,
+		curr(parser).Span(),
+		"unexpected symbol %s, expected %s",
+		describe(curr(parser)),
+		lexer.LexemeTypeLabels[symbol]))
 
-	err := errors.New(errorMessage)
+	return false
+}
 
-	fmt.Println(err)
+func report(parser *Parser, diagnostic diagnostic.Diagnostic) {
+	if parser.panicking {
+		return
+	}
 
-	return false
+	parser.panicking = true
+	parser.Diagnostics = append(parser.Diagnostics, diagnostic)
+}
+
+// synchronize skips symbols until the end of the broken statement: past a ";",
+// or up to a "}" or a keyword which starts a new statement
+func synchronize(parser *Parser) {
+	for hasNext(parser) {
+		if prev(parser).Type == lexer.LT_SEMICOLON {
+			return
+		}
+
+		switch parser.currentSym {
+		case lexer.LT_RCURLY, lexer.LT_CONST, lexer.LT_VAL, lexer.LT_IF, lexer.LT_RETURN,
+			lexer.LT_WHILE, lexer.LT_FOR, lexer.LT_BREAK, lexer.LT_CONTINUE, lexer.LT_STRUCT, lexer.LT_INTERFACE:
+			return
+		}
+
+		next(parser)
+	}
+}
+
+// spanFrom returns the span from the start of the lexeme to the end of the last accepted lexeme
+func spanFrom(parser *Parser, start lexer.Lexeme) diagnostic.Span {
+	span := start.Span()
+
+	if parser.currentStep > 0 && prev(parser).Offset >= start.Offset {
+		span.End = prev(parser).End
+	}
+
+	return span
+}
+
+func joinSpans(start diagnostic.Span, end diagnostic.Span) diagnostic.Span {
+	return diagnostic.Span{Start: start.Start, End: end.End}
+}
+
+// describe names a lexeme for error messages, e.g. LT_IDENTIFIER "foo"
+func describe(lexeme lexer.Lexeme) string {
+	if lexeme.Label == "" {
+		return lexer.LexemeTypeLabels[lexeme.Type]
+	}
+
+	return fmt.Sprintf("%s %q", lexer.LexemeTypeLabels[lexeme.Type], lexeme.Label)
 }
 
 type ExpressionType int
@@ -104,6 +150,7 @@ const (
 	ET_EXPRESSION_ARRAY
 	ET_FUNCTION_CALL
 	ET_MEMBER_ACCESS
+	ET_ERROR
 )
 
 var ExpressionTypeLabels = map[ExpressionType]string{
@@ -115,6 +162,7 @@ var ExpressionTypeLabels = map[ExpressionType]string{
 	ET_EXPRESSION_ARRAY: "ET_EXPRESSION_ARRAY",
 	ET_FUNCTION_CALL:    "ET_FUNCTION_CALL",
 	ET_MEMBER_ACCESS:    "ET_MEMBER_ACCESS",
+	ET_ERROR:            "ET_ERROR",
 }
 
 const (
@@ -126,6 +174,13 @@ const (
 	ST_STRUCT
 	ST_IF
 	ST_RETURN
+	ST_ASSIGNMENT
+	ST_WHILE
+	ST_FOR
+	ST_BREAK
+	ST_CONTINUE
+	ST_INTERFACE
+	ST_ERROR
 )
 
 var StatementTypeLabels = map[StatementType]string{
@@ -137,6 +192,26 @@ var StatementTypeLabels = map[StatementType]string{
 	ST_STRUCT:          "ST_STRUCT",
 	ST_IF:              "ST_IF",
 	ST_RETURN:          "ST_RETURN",
+	ST_ASSIGNMENT:      "ST_ASSIGNMENT",
+	ST_WHILE:           "ST_WHILE",
+	ST_FOR:             "ST_FOR",
+	ST_BREAK:           "ST_BREAK",
+	ST_CONTINUE:        "ST_CONTINUE",
+	ST_INTERFACE:       "ST_INTERFACE",
+	ST_ERROR:           "ST_ERROR",
+}
+
+// BindingKind tells whether a declared name can be assigned to
+type BindingKind int
+
+const (
+	BK_CONST BindingKind = iota
+	BK_VAL
+)
+
+var BindingKindLabels = map[BindingKind]string{
+	BK_CONST: "BK_CONST",
+	BK_VAL:   "BK_VAL",
 }
 
 const (
@@ -147,10 +222,12 @@ const (
 	TYPE_NUMBER
 	TYPE_FLOAT
 	TYPE_BOOL
+	TYPE_VOID
 
 	// Complex types
 	TYPE_STRUCT
 	TYPE_FUNCTION
+	TYPE_INTERFACE
 )
 
 var LiteralTypeLabels = map[ValueType]string{
@@ -160,9 +237,11 @@ var LiteralTypeLabels = map[ValueType]string{
 	TYPE_NUMBER: "TYPE_NUMBER",
 	TYPE_FLOAT:  "TYPE_FLOAT",
 	TYPE_BOOL:   "TYPE_BOOL",
+	TYPE_VOID:   "TYPE_VOID",
 
-	TYPE_STRUCT:   "TYPE_STRUCT",
-	TYPE_FUNCTION: "TYPE_FUNCTION",
+	TYPE_STRUCT:    "TYPE_STRUCT",
+	TYPE_FUNCTION:  "TYPE_FUNCTION",
+	TYPE_INTERFACE: "TYPE_INTERFACE",
 }
 
 type AST_Expression struct {
@@ -173,15 +252,41 @@ type AST_Expression struct {
 	Value        *AST_Value
 	FunctionCall *AST_FunctionCall
 	Rhs          *AST_Expression
+	Span         diagnostic.Span
+	// Set by the type checker. The members in the Rhs chain of a member
+	// access have the type of the value they are accessed on.
+	Type *AST_Type
+	// Set by the type checker when a struct value is used as this interface
+	Interface *AST_Type
 }
 
-type AST_Struct struct{}
+// AST_Struct is the declaration "struct Name { field: type, ... }" or
+// "interface Name { member: type, ... }", whose fields have a Type, or the
+// literal "Name { field: value, ... }", whose fields have a Value
+type AST_Struct struct {
+	Name   string
+	Fields []*AST_Field
+	Span   diagnostic.Span
+}
+
+type AST_Field struct {
+	Name  string
+	Type  *AST_Type
+	Value *AST_Expression
+	Span  diagnostic.Span
+}
 
+// Literal is the source text of number and bool literals and the decoded
+// text of strings. Numbers also carry their parsed value, integers in both
+// Integer and Float, and their optional type Suffix (u8, i64, f32, ...).
 type AST_Value struct {
 	Literal  string
 	Function *AST_Function
 	Struct   *AST_Struct
 	Type     ValueType
+	Integer  uint64
+	Float    float64
+	Suffix   string
 }
 
 type AST_FunctionCall struct {
@@ -189,20 +294,58 @@ type AST_FunctionCall struct {
 	Params []*AST_Expression
 }
 
+// AST_If is a branch of an if chain. Else is the next elseif branch, or the
+// final else branch which has no Condition.
 type AST_If struct {
 	Condition  *AST_Expression
 	Statements []*AST_Statement
+	Else       *AST_If
 }
 
+// AST_Param is a function parameter, Type is nil without an annotation
+type AST_Param struct {
+	Name string
+	Type *AST_Type
+	Span diagnostic.Span
+}
+
+// Result is the annotated result type, nil without an annotation
 type AST_Function struct {
 	Name      string
-	Props     []string
+	Props     []*AST_Param
+	Result    *AST_Type
 	Statement *AST_Statement
+	Span      diagnostic.Span
+	// TYPE_FUNCTION with the parameter and result types, set by the type checker
+	Type *AST_Type
 }
 
+// Type is the annotated type, nil without an annotation. Value is nil for
+// "val name: type;".
 type AST_Declaration struct {
 	Name  string
+	Kind  BindingKind
+	Type  *AST_Type
 	Value *AST_Expression
+	Span  diagnostic.Span
+}
+
+// AST_Assignment is "target = value" or a compound assignment such as
+// "target += value", Operator is LT_EQUALS or LT_PLUS_EQUALS, ...
+type AST_Assignment struct {
+	Target   *AST_Expression
+	Operator lexer.LexemeType
+	Value    *AST_Expression
+}
+
+// AST_Loop is "while (Condition) {...}" or "for (Variable of Collection) {...}".
+// The variable of a for loop is a const declaration without a value,
+// numbers are iterated from 0 up to the number and strings by character.
+type AST_Loop struct {
+	Condition  *AST_Expression
+	Variable   *AST_Declaration
+	Collection *AST_Expression
+	Statements []*AST_Statement
 }
 
 type AST_Statement struct {
@@ -213,6 +356,28 @@ type AST_Statement struct {
 	Function    *AST_Function
 	Declaration *AST_Declaration
 	If          *AST_If
+	Assignment  *AST_Assignment
+	Loop        *AST_Loop
+	Struct      *AST_Struct
+	Span        diagnostic.Span
+}
+
+var assignmentOperators = map[lexer.LexemeType]bool{
+	lexer.LT_EQUALS:          true,
+	lexer.LT_PLUS_EQUALS:     true,
+	lexer.LT_MINUS_EQUALS:    true,
+	lexer.LT_MULTIPLY_EQUALS: true,
+	lexer.LT_DIVIDE_EQUALS:   true,
+	lexer.LT_MODULO_EQUALS:   true,
+}
+
+// CompoundOperators maps compound assignments to their binary operator
+var CompoundOperators = map[lexer.LexemeType]lexer.LexemeType{
+	lexer.LT_PLUS_EQUALS:     lexer.LT_PLUS,
+	lexer.LT_MINUS_EQUALS:    lexer.LT_MINUS,
+	lexer.LT_MULTIPLY_EQUALS: lexer.LT_MULTIPLY,
+	lexer.LT_DIVIDE_EQUALS:   lexer.LT_DIVIDE,
+	lexer.LT_MODULO_EQUALS:   lexer.LT_MODULO,
 }
 
 type AST_Program struct {
@@ -248,7 +413,7 @@ func createFunctionDeclarationNode(name string, function *AST_Function) *AST_Dec
 	return decl
 }
 
-func createFunctionNode(name string, props []string, statement *AST_Statement) *AST_Function {
+func createFunctionNode(name string, props []*AST_Param, statement *AST_Statement) *AST_Function {
 	fn := &AST_Function{
 		Name:      name,
 		Props:     props,
@@ -290,12 +455,14 @@ func createExpressionIdentifierNode(value string) *AST_Expression {
 
 func createExpressionUnaryNode(operator lexer.LexemeType, rhs *AST_Expression) *AST_Expression {
 	_rhs := &AST_Expression{
-		EType:      rhs.EType,
-		Lhs:        rhs.Lhs,
-		Operator:   rhs.Operator,
-		Rhs:        rhs.Rhs,
-		Identifier: rhs.Identifier,
-		Value:      rhs.Value,
+		EType:        rhs.EType,
+		Lhs:          rhs.Lhs,
+		Operator:     rhs.Operator,
+		Rhs:          rhs.Rhs,
+		Identifier:   rhs.Identifier,
+		Value:        rhs.Value,
+		FunctionCall: rhs.FunctionCall,
+		Span:         rhs.Span,
 	}
 
 	expr := &AST_Expression{
@@ -309,21 +476,25 @@ func createExpressionUnaryNode(operator lexer.LexemeType, rhs *AST_Expression) *
 
 func createExpressionBinaryNode(lhs *AST_Expression, operator lexer.LexemeType, rhs *AST_Expression) *AST_Expression {
 	_lhs := &AST_Expression{
-		EType:      lhs.EType,
-		Lhs:        lhs.Lhs,
-		Operator:   lhs.Operator,
-		Rhs:        lhs.Rhs,
-		Identifier: lhs.Identifier,
-		Value:      lhs.Value,
+		EType:        lhs.EType,
+		Lhs:          lhs.Lhs,
+		Operator:     lhs.Operator,
+		Rhs:          lhs.Rhs,
+		Identifier:   lhs.Identifier,
+		Value:        lhs.Value,
+		FunctionCall: lhs.FunctionCall,
+		Span:         lhs.Span,
 	}
 
 	_rhs := &AST_Expression{
-		EType:      rhs.EType,
-		Lhs:        rhs.Lhs,
-		Operator:   rhs.Operator,
-		Rhs:        rhs.Rhs,
-		Identifier: rhs.Identifier,
-		Value:      rhs.Value,
+		EType:        rhs.EType,
+		Lhs:          rhs.Lhs,
+		Operator:     rhs.Operator,
+		Rhs:          rhs.Rhs,
+		Identifier:   rhs.Identifier,
+		Value:        rhs.Value,
+		FunctionCall: rhs.FunctionCall,
+		Span:         rhs.Span,
 	}
 
 	expr := &AST_Expression{
@@ -332,6 +503,7 @@ func createExpressionBinaryNode(lhs *AST_Expression, operator lexer.LexemeType,
 		Operator:   operator,
 		Rhs:        _rhs,
 		Identifier: "",
+		Span:       joinSpans(lhs.Span, rhs.Span),
 	}
 
 	return expr
@@ -362,6 +534,22 @@ func createExpressionFunctionCallNode(name string, params []*AST_Expression) *AS
 	return expr
 }
 
+func createExpressionErrorNode() *AST_Expression {
+	expr := &AST_Expression{
+		EType: ET_ERROR,
+	}
+
+	return expr
+}
+
+func createErrorStatementNode() *AST_Statement {
+	statement := &AST_Statement{
+		SType: ST_ERROR,
+	}
+
+	return statement
+}
+
 func createExpressionMemberAccessNode(lhs *AST_Expression, member *AST_Expression) *AST_Expression {
 	expr := &AST_Expression{
 		EType: ET_MEMBER_ACCESS,
@@ -376,11 +564,11 @@ func Create(lexer lexer.Lexer) Parser {
 	return Parser{lexemes: lexer.Lexemes, currentLexeme: lexer.Lexemes[0], currentSym: lexer.Lexemes[0].Type}
 }
 
-func (parser *Parser) Start() *AST_Program {
+func (parser *Parser) Start() (*AST_Program, []diagnostic.Diagnostic) {
 
 	program := program(parser)
 
-	return program
+	return program, parser.Diagnostics
 }
 
 /*
@@ -391,12 +579,19 @@ program -> (statement) END
 
 statement 	-> IF "(" expression ")" "{" statement "}"
 			-> WHILE "(" expression ")" "{" statement "}"
+			-> FOR "(" IDENTIFIER OF expression ")" "{" statement "}"
+			-> BREAK ";" | CONTINUE ";"
+			-> ( STRUCT | INTERFACE ) IDENTIFIER "{" (IDENTIFIER ":" type ",")* "}"
 			-> IMPORT STRING
 			-> LET IDENTIFIER ( ";" | "=" expression ";" )
 
+On a syntax error the statement is replaced with ST_ERROR and the parser skips
+to the next ";", "}" or a keyword starting a statement before continuing.
+
 */
 
 func program(parser *Parser) *AST_Program {
+	defer leave(enter(parser, "program"))
 
 	program := createProgramNode()
 
@@ -409,24 +604,58 @@ func program(parser *Parser) *AST_Program {
 		continue
 	}
 
-	if accept(parser, lexer.LT_END) {
-		fmt.Println("Finished")
-	}
+	accept(parser, lexer.LT_END)
 
 	return program
 }
 
 func statement(parser *Parser) *AST_Statement {
+	defer leave(enter(parser, "statement"))
+
+	start := curr(parser)
+	startStep := parser.currentStep
+	recovering := parser.panicking
+
+	currentStatement := statementBody(parser)
+
+	if parser.panicking {
+		// Always make progress, otherwise the enclosing block would loop on the same symbol
+		if parser.currentStep == startStep {
+			next(parser)
+		}
+
+		// Errors started by an enclosing statement are recovered by that statement
+		if !recovering {
+			synchronize(parser)
+			parser.panicking = false
+
+			currentStatement = createErrorStatementNode()
+		}
+	}
+
+	currentStatement.Span = spanFrom(parser, start)
+
+	return currentStatement
+}
+
+func statementBody(parser *Parser) *AST_Statement {
 
 	currentStatement := &AST_Statement{}
 
 	if accept(parser, lexer.LT_VAL) || accept(parser, lexer.LT_CONST) { // LET / CONST
 
-		// variableType := prev(parser)
+		binding := BK_CONST
+
+		if prev(parser).Type == lexer.LT_VAL {
+			binding = BK_VAL
+		}
 
 		if accept(parser, lexer.LT_MACRO) {
 			// TODO Is macro
-			log.Panic("Macro not implemented yet")
+			report(parser, diagnostic.Error(
+				diagnostic.CODE_MACRO_NOT_IMPLEMENTED,
+				prev(parser).Span(),
+				"macros are not implemented yet"))
 		}
 
 		// Declare variable type here to be used later
@@ -434,16 +663,42 @@ func statement(parser *Parser) *AST_Statement {
 
 			identifier := prev(parser)
 
+			var declared *AST_Type
+
+			if accept(parser, lexer.LT_COLON) { // LET / CONST {name}: {type}
+				declared = typeAnnotation(parser)
+
+				if accept(parser, lexer.LT_SEMICOLON) { // LET / CONST {name}: {type};
+					currentStatement.SType = ST_DECLARATION
+					currentStatement.Declaration = createDeclarationNode(identifier.Label, nil)
+					currentStatement.Declaration.Kind = binding
+					currentStatement.Declaration.Type = declared
+					currentStatement.Declaration.Span = spanFrom(parser, identifier)
+
+					return currentStatement
+				}
+			}
+
 			if expect(parser, lexer.LT_EQUALS) { // LET / CONST {name} =
 				if accept(parser, lexer.LT_LPAREN) { // LET / CONST {name} = (
 
-					params := make([]string, 0)
+					functionStart := prev(parser)
+
+					params := make([]*AST_Param, 0)
 
 					// Parse function
 					for { // n1, n2, .. nx )
 						if accept(parser, lexer.LT_IDENTIFIER) {
 
-							params = append(params, prev(parser).Label)
+							name := prev(parser)
+							param := &AST_Param{Name: name.Label}
+
+							if accept(parser, lexer.LT_COLON) { // n1: {type}
+								param.Type = typeAnnotation(parser)
+							}
+
+							param.Span = spanFrom(parser, name)
+							params = append(params, param)
 
 							if accept(parser, lexer.LT_RPAREN) {
 								break
@@ -456,6 +711,12 @@ func statement(parser *Parser) *AST_Statement {
 						}
 					}
 
+					var result *AST_Type
+
+					if accept(parser, lexer.LT_COLON) { // LET / CONST {name} = ((params)): {type}
+						result = typeAnnotation(parser)
+					}
+
 					expect(parser, lexer.LT_LAMBDA) // LET / CONST {name} = ((params)) =>
 
 					currentStatement.SType = ST_DECLARATION
@@ -468,7 +729,7 @@ func statement(parser *Parser) *AST_Statement {
 						functionStatements.Statements = make([]*AST_Statement, 0)
 
 						for {
-							if accept(parser, lexer.LT_RCURLY) {
+							if accept(parser, lexer.LT_RCURLY) || !expectBlockEnd(parser) {
 								break
 							}
 
@@ -484,8 +745,14 @@ func statement(parser *Parser) *AST_Statement {
 					}
 
 					function := createFunctionNode(identifier.Label, params, functionStatements)
+					function.Result = result
+					function.Span = spanFrom(parser, functionStart)
 
 					currentStatement.Declaration = createFunctionDeclarationNode(identifier.Label, function)
+					currentStatement.Declaration.Kind = binding
+					currentStatement.Declaration.Type = declared
+					currentStatement.Declaration.Value.Span = function.Span
+					currentStatement.Declaration.Span = spanFrom(parser, identifier)
 
 					return currentStatement
 				} else {
@@ -493,6 +760,9 @@ func statement(parser *Parser) *AST_Statement {
 					expr := expression(parser)
 
 					decl := createDeclarationNode(identifier.Label, expr)
+					decl.Kind = binding
+					decl.Type = declared
+					decl.Span = spanFrom(parser, identifier)
 
 					currentStatement.SType = ST_DECLARATION
 					currentStatement.Declaration = decl
@@ -503,33 +773,76 @@ func statement(parser *Parser) *AST_Statement {
 			}
 		}
 	} else if accept(parser, lexer.LT_IF) { // IF
+		currentStatement.SType = ST_IF
+		currentStatement.If = ifBranch(parser)
+
+		return currentStatement
+
+	} else if accept(parser, lexer.LT_WHILE) { // WHILE
+		currentStatement.SType = ST_WHILE
+		currentStatement.Loop = &AST_Loop{}
+
 		expect(parser, lexer.LT_LPAREN)
+		currentStatement.Loop.Condition = expression(parser)
+		expect(parser, lexer.LT_RPAREN)
 
-		currentStatement.SType = ST_IF
+		currentStatement.Loop.Statements = block(parser)
 
-		expr := expression(parser)
+		return currentStatement
+	} else if accept(parser, lexer.LT_FOR) { // FOR
+		currentStatement.SType = ST_FOR
+		currentStatement.Loop = &AST_Loop{}
+
+		expect(parser, lexer.LT_LPAREN)
 
-		condition := &AST_If{
-			Condition:  expr,
-			Statements: make([]*AST_Statement, 0),
+		if expect(parser, lexer.LT_IDENTIFIER) {
+			currentStatement.Loop.Variable = createDeclarationNode(prev(parser).Label, nil)
+			currentStatement.Loop.Variable.Span = prev(parser).Span()
 		}
 
+		expect(parser, lexer.LT_OF)
+		currentStatement.Loop.Collection = expression(parser)
 		expect(parser, lexer.LT_RPAREN)
 
-		if accept(parser, lexer.LT_LCURLY) {
-			for {
-				if accept(parser, lexer.LT_RCURLY) {
-					break
-				}
+		currentStatement.Loop.Statements = block(parser)
 
-				condition.Statements = append(condition.Statements, statement(parser))
-			}
+		return currentStatement
+	} else if accept(parser, lexer.LT_BREAK) || accept(parser, lexer.LT_CONTINUE) { // BREAK / CONTINUE
+		currentStatement.SType = ST_BREAK
+
+		if prev(parser).Type == lexer.LT_CONTINUE {
+			currentStatement.SType = ST_CONTINUE
 		}
 
-		currentStatement.If = condition
+		expect(parser, lexer.LT_SEMICOLON)
 
 		return currentStatement
+	} else if accept(parser, lexer.LT_STRUCT) || accept(parser, lexer.LT_INTERFACE) { // STRUCT / INTERFACE
+		start := prev(parser)
+
+		if !expect(parser, lexer.LT_IDENTIFIER) { // STRUCT / INTERFACE {name}
+			return createErrorStatementNode()
+		}
+
+		currentStatement.SType = ST_STRUCT
 
+		if start.Type == lexer.LT_INTERFACE {
+			currentStatement.SType = ST_INTERFACE
+		}
+
+		currentStatement.Struct = &AST_Struct{Name: prev(parser).Label}
+
+		expect(parser, lexer.LT_LCURLY)
+
+		currentStatement.Struct.Fields = fields(parser, func(field *AST_Field) {
+			expect(parser, lexer.LT_COLON)
+			field.Type = typeAnnotation(parser)
+		})
+		currentStatement.Struct.Span = spanFrom(parser, start)
+
+		accept(parser, lexer.LT_SEMICOLON)
+
+		return currentStatement
 	} else if accept(parser, lexer.LT_RETURN) { // RETURN
 		currentStatement.SType = ST_RETURN
 		currentStatement.Expression = expression(parser)
@@ -539,6 +852,26 @@ func statement(parser *Parser) *AST_Statement {
 	} else {
 		expr := expression(parser)
 
+		if operator := parser.currentSym; assignmentOperators[operator] && accept(parser, operator) { // {target} = {value}
+			if !isAssignable(expr) {
+				report(parser, diagnostic.Error(
+					diagnostic.CODE_INVALID_ASSIGNMENT,
+					expr.Span,
+					"cannot assign to %s", ExpressionTypeLabels[expr.EType]))
+			}
+
+			currentStatement.SType = ST_ASSIGNMENT
+			currentStatement.Assignment = &AST_Assignment{
+				Target:   expr,
+				Operator: operator,
+				Value:    expression(parser),
+			}
+
+			expect(parser, lexer.LT_SEMICOLON)
+
+			return currentStatement
+		}
+
 		currentStatement.SType = ST_EXPRESSION
 		currentStatement.Expression = expr
 
@@ -547,8 +880,115 @@ func statement(parser *Parser) *AST_Statement {
 		return currentStatement
 	}
 
-	log.Panic("Unexpected path")
-	return nil
+	// The declaration was malformed, the error has already been reported by expect
+	return createErrorStatementNode()
+}
+
+// ifBranch -> LT_LPAREN expression LT_RPAREN block ((LT_ELSEIF | LT_ELSE LT_IF) ifBranch | LT_ELSE block)?
+func ifBranch(parser *Parser) *AST_If {
+	expect(parser, lexer.LT_LPAREN)
+
+	branch := &AST_If{Condition: expression(parser)}
+
+	expect(parser, lexer.LT_RPAREN)
+
+	branch.Statements = block(parser)
+
+	if accept(parser, lexer.LT_ELSEIF) {
+		branch.Else = ifBranch(parser)
+	} else if accept(parser, lexer.LT_ELSE) {
+		if accept(parser, lexer.LT_IF) { // ELSE IF
+			branch.Else = ifBranch(parser)
+		} else {
+			branch.Else = &AST_If{Statements: block(parser)}
+		}
+	}
+
+	return branch
+}
+
+// block parses the statements of a braced block, a missing "{" is reported
+// instead of leaving the block empty
+func block(parser *Parser) []*AST_Statement {
+	statements := make([]*AST_Statement, 0)
+
+	if !accept(parser, lexer.LT_LCURLY) {
+		report(parser, diagnostic.Error(
+			diagnostic.CODE_EXPECTED_BLOCK,
+			curr(parser).Span(),
+			"expected a block in braces, found %s",
+			describe(curr(parser))))
+
+		return statements
+	}
+
+	for {
+		if accept(parser, lexer.LT_RCURLY) || !expectBlockEnd(parser) {
+			break
+		}
+
+		statements = append(statements, statement(parser))
+	}
+
+	return statements
+}
+
+// fields -> (LT_IDENTIFIER field (LT_COMMA LT_IDENTIFIER field)* LT_COMMA?)? LT_RCURLY
+// where field parses what follows the name of each field
+func fields(parser *Parser, field func(*AST_Field)) []*AST_Field {
+	result := make([]*AST_Field, 0)
+
+	for {
+		if accept(parser, lexer.LT_RCURLY) || !expectBlockEnd(parser) || parser.panicking {
+			break
+		}
+
+		if !expect(parser, lexer.LT_IDENTIFIER) {
+			break
+		}
+
+		name := prev(parser)
+		current := &AST_Field{Name: name.Label}
+
+		field(current)
+
+		current.Span = spanFrom(parser, name)
+		result = append(result, current)
+
+		if !accept(parser, lexer.LT_COMMA) {
+			expect(parser, lexer.LT_RCURLY)
+			break
+		}
+	}
+
+	return result
+}
+
+// isAssignable reports whether the expression names a variable or a member, e.g. x or a.b
+func isAssignable(expression *AST_Expression) bool {
+	switch expression.EType {
+	case ET_IDENTIFIER:
+		return true
+	case ET_MEMBER_ACCESS:
+		last := expression
+
+		for last.Rhs != nil {
+			last = last.Rhs
+		}
+
+		return last != expression && last.Lhs.EType == ET_IDENTIFIER
+	}
+
+	return false
+}
+
+// expectBlockEnd reports a missing "}" when the source ends inside a block
+func expectBlockEnd(parser *Parser) bool {
+	if hasNext(parser) {
+		return true
+	}
+
+	return expect(parser, lexer.LT_RCURLY)
 }
 
 /*
@@ -561,14 +1001,15 @@ compare -> term (( LT_LESS | LT_GREATER | LT_LEQ | LT_LGE | LT_EQ | LT_NE ) term
 
 term -> factor (( LT_PLUS | LT_MINUS ) factor)*
 
-factor -> unary (( LT_DIVIDE | LT_MULTIPLY ) unary)*
+factor -> unary (( LT_DIVIDE | LT_MULTIPLY | LT_MODULO ) unary)*
 
 unary -> ( LT_BANG | LT_MINUS | LT_PLUS ) unary | primary
 
-primary -> LT_NUMBER | LT_FLOAT | LT_LPAREN expression LT_RPAREN | LT_IDENTIFIER | "function call"
+primary -> LT_NUMBER | LT_FLOAT | LT_LPAREN expression LT_RPAREN | LT_IDENTIFIER | "function call" | "struct literal"
 */
 
 func expression(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "expression"))
 
 	lhs := compareOR(parser)
 
@@ -578,6 +1019,7 @@ func expression(parser *Parser) *AST_Expression {
 			Lhs:   lhs,
 			Rhs:   expression(parser),
 		}
+		expression.Span = joinSpans(lhs.Span, expression.Rhs.Span)
 
 		return expression
 	}
@@ -591,6 +1033,7 @@ func safeExpression(parser *Parser) *AST_Expression {
 }
 
 func compareOR(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "compareOR"))
 
 	lhs := compareAND(parser)
 
@@ -605,6 +1048,7 @@ func compareOR(parser *Parser) *AST_Expression {
 }
 
 func compareAND(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "compareAND"))
 
 	lhs := compareNEQEQ(parser)
 
@@ -619,6 +1063,7 @@ func compareAND(parser *Parser) *AST_Expression {
 }
 
 func compareNEQEQ(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "compareNEQEQ"))
 
 	lhs := compareLEQGEQLTGT(parser)
 
@@ -633,6 +1078,7 @@ func compareNEQEQ(parser *Parser) *AST_Expression {
 }
 
 func compareLEQGEQLTGT(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "compareLEQGEQLTGT"))
 
 	lhs := term(parser)
 
@@ -647,6 +1093,7 @@ func compareLEQGEQLTGT(parser *Parser) *AST_Expression {
 }
 
 func term(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "term"))
 
 	lhs := factor(parser)
 
@@ -661,10 +1108,11 @@ func term(parser *Parser) *AST_Expression {
 }
 
 func factor(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "factor"))
 
 	lhs := unary(parser)
 
-	for accept(parser, lexer.LT_MULTIPLY) || accept(parser, lexer.LT_DIVIDE) {
+	for accept(parser, lexer.LT_MULTIPLY) || accept(parser, lexer.LT_DIVIDE) || accept(parser, lexer.LT_MODULO) {
 		operator := prev(parser).Type
 
 		rhs := unary(parser)
@@ -675,12 +1123,18 @@ func factor(parser *Parser) *AST_Expression {
 }
 
 func unary(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "unary"))
 
 	if accept(parser, lexer.LT_BANG) || accept(parser, lexer.LT_MINUS) {
-		operator := prev(parser).Type
+		start := prev(parser)
+		operator := start.Type
 
 		rhs := unary(parser)
-		return createExpressionUnaryNode(operator, rhs)
+
+		expr := createExpressionUnaryNode(operator, rhs)
+		expr.Span = spanFrom(parser, start)
+
+		return expr
 	}
 
 	rhs := memberAccess(parser)
@@ -689,6 +1143,8 @@ func unary(parser *Parser) *AST_Expression {
 
 // memberAccess -> primary ((LT_PERIOD primary)*)
 func memberAccess(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "memberAccess"))
+	start := curr(parser)
 	lhs := primary(parser)
 	root := lhs
 
@@ -711,6 +1167,7 @@ func memberAccess(parser *Parser) *AST_Expression {
 				EType: ET_MEMBER_ACCESS,
 				Lhs:   member,
 				Rhs:   nil,
+				Span:  member.Span,
 			}
 
 			lhs = lhs.Rhs
@@ -721,10 +1178,13 @@ func memberAccess(parser *Parser) *AST_Expression {
 		break
 	}
 
+	root.Span = spanFrom(parser, start)
+
 	return root
 }
 
 func primary(parser *Parser) *AST_Expression {
+	defer leave(enter(parser, "primary"))
 
 	if accept(parser, lexer.LT_LITERAL_NUMBER) || accept(parser, lexer.LT_LITERAL_FLOAT) || accept(parser, lexer.LT_LITERAL_STRING) || accept(parser, lexer.LT_LITERAL_BOOL) {
 		rhs := prev(parser).Label
@@ -739,20 +1199,31 @@ func primary(parser *Parser) *AST_Expression {
 			t = TYPE_FLOAT
 		case lexer.LT_LITERAL_STRING:
 			t = TYPE_STRING
+			// Strings carry their decoded value rather than the quoted source text
+			rhs = prev(parser).Value
 		case lexer.LT_LITERAL_BOOL:
 			t = TYPE_BOOL
 		}
 
 		expr := createExpressionLiteralNode(rhs, t)
+		expr.Span = prev(parser).Span()
+
+		if t == TYPE_NUMBER || t == TYPE_FLOAT {
+			// Invalid numbers have already been reported by the lexer
+			expr.Value.Integer, expr.Value.Float, _ = lexer.NumberValue(prev(parser))
+			expr.Value.Suffix = prev(parser).Suffix
+		}
+
 		return expr
 	} else if accept(parser, lexer.LT_IDENTIFIER) {
-		name := prev(parser).Label
+		start := prev(parser)
+		name := start.Label
 
 		expressions := make([]*AST_Expression, 0)
 
 		if accept(parser, lexer.LT_LPAREN) {
 			for {
-				if accept(parser, lexer.LT_RPAREN) {
+				if accept(parser, lexer.LT_RPAREN) || !expectParenEnd(parser) || parser.panicking {
 					break
 				}
 				expressions = append(expressions, safeExpression(parser))
@@ -761,25 +1232,62 @@ func primary(parser *Parser) *AST_Expression {
 			}
 
 			expr := createExpressionFunctionCallNode(name, expressions)
+			expr.Span = spanFrom(parser, start)
+
+			return expr
+		}
+
+		if accept(parser, lexer.LT_LCURLY) { // {name} { field: value, ... }
+			literal := &AST_Struct{Name: name}
+			literal.Fields = fields(parser, func(field *AST_Field) {
+				expect(parser, lexer.LT_COLON)
+				field.Value = safeExpression(parser)
+			})
+			literal.Span = spanFrom(parser, start)
+
+			expr := createExpressionLiteralNode(name, TYPE_STRUCT)
+			expr.Value.Struct = literal
+			expr.Span = literal.Span
 
 			return expr
 		}
 
 		expr := createExpressionIdentifierNode(name)
+		expr.Span = start.Span()
 		return expr
 
 		//TODO: Move this after unary and before member access
 	} else if accept(parser, lexer.LT_LPAREN) {
+		start := prev(parser)
 		expr := expression(parser)
 
 		expr = createExpressionGroupNode(expr)
 
 		expect(parser, lexer.LT_RPAREN)
 
+		expr.Span = spanFrom(parser, start)
+
 		// fmt.Printf("After group, current lexeme: %s\n", currentLexeme(parser).Label)
 		return expr
 	} else {
-		log.Panic("Unexpected path")
-		return nil
+		report(parser, diagnostic.Error(
+			diagnostic.CODE_EXPECTED_EXPRESSION,
+			curr(parser).Span(),
+			"expected expression, found %s",
+			describe(curr(parser))))
+
+		expr := createExpressionErrorNode()
+		expr.Span = curr(parser).Span()
+
+		return expr
 	}
 }
+
+// expectParenEnd reports a missing ")" when the source ends inside an argument list
+func expectParenEnd(parser *Parser) bool {
+	if hasNext(parser) {
+		return true
+	}
+
+	return expect(parser, lexer.LT_RPAREN)
+}
@@ -0,0 +1,368 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+)
+
+func parse(input string) (*AST_Program, []diagnostic.Diagnostic) {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	parser := Create(mainLexer)
+
+	return parser.Start()
+}
+
+func TestParserValidProgram(t *testing.T) {
+	input := "const a = 1 + 2;\nconst f = (x) => {\n return x;\n};"
+
+	program, diagnostics := parse(input)
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics for a valid program", len(diagnostics))
+		return
+	}
+
+	if len(program.Statements) != 2 {
+		t.Errorf("parser.Start statements size is incorrect. Expected 2 got %d", len(program.Statements))
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	input := "const a = ;"
+
+	_, diagnostics := parse(input)
+
+	if !diagnostic.HasErrors(diagnostics) {
+		t.Error("parser.Start did not report an error for a missing expression")
+		return
+	}
+
+	if diagnostics[0].Code != diagnostic.CODE_EXPECTED_EXPRESSION {
+		t.Errorf("parser.Start first diagnostic code is incorrect %s", diagnostics[0].Code)
+	}
+}
+
+func TestParserModulo(t *testing.T) {
+	program, diagnostics := parse("const a = 1 + 7 % 3;")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	if rhs := program.Statements[0].Declaration.Value.Rhs; rhs.EType != ET_BINARY || rhs.Operator != lexer.LT_MODULO {
+		t.Errorf("parser.Start did not bind %% tighter than + %+v", rhs)
+	}
+}
+
+func TestParserUnterminatedBlock(t *testing.T) {
+	input := "const f = (x) => {"
+
+	_, diagnostics := parse(input)
+
+	if !diagnostic.HasErrors(diagnostics) {
+		t.Error("parser.Start did not report an error for an unterminated block")
+	}
+}
+
+func TestParserSpans(t *testing.T) {
+	input := "const a = 1;\nconst b = a +\n  foo(2);"
+
+	program, _ := parse(input)
+
+	declaration := program.Statements[1].Declaration
+
+	if declaration.Span.Start.Row != 2 || declaration.Span.Start.Column != 7 {
+		t.Errorf("declaration span starts at %s", declaration.Span.Start)
+	}
+
+	value := declaration.Value
+
+	if value.Span.Start.String() != "2:11" || value.Span.End.String() != "3:9" {
+		t.Errorf("binary expression span is incorrect %s-%s", value.Span.Start, value.Span.End)
+	}
+
+	if value.Rhs.FunctionCall == nil || value.Rhs.Span.Start.String() != "3:3" {
+		t.Errorf("function call operand span is incorrect %s", value.Rhs.Span.Start)
+	}
+
+	if program.Statements[1].Span.End.Offset != len(input) {
+		t.Errorf("statement span ends at offset %d, expected %d", program.Statements[1].Span.End.Offset, len(input))
+	}
+}
+
+func TestParserRecovery(t *testing.T) {
+	input := "const a = ;\nconst b = 1 +;\nf(;\nconst c = (x) => {\n  return * 2;\n  const d = 3;\n};\nconst e = 4;"
+
+	program, diagnostics := parse(input)
+
+	if len(diagnostics) != 4 {
+		for _, element := range diagnostics {
+			t.Log(element.Format("input"))
+		}
+		t.Errorf("parser.Start reported %d diagnostics, expected 4", len(diagnostics))
+	}
+
+	if len(program.Statements) != 5 {
+		t.Errorf("parser.Start statements size is incorrect. Expected 5 got %d", len(program.Statements))
+		return
+	}
+
+	if program.Statements[0].SType != ST_ERROR || program.Statements[2].SType != ST_ERROR {
+		t.Error("parser.Start did not record error statements")
+	}
+
+	body := program.Statements[3].Declaration.Value.Value.Function.Statement.Statements
+
+	if len(body) != 2 || body[0].SType != ST_ERROR || body[1].SType != ST_DECLARATION {
+		t.Error("parser.Start did not recover inside the function body")
+	}
+
+	if program.Statements[4].SType != ST_DECLARATION || program.Statements[4].Declaration.Name != "e" {
+		t.Error("parser.Start did not recover after the function")
+	}
+}
+
+type recordingTracer struct {
+	events []TraceEvent
+}
+
+func (tracer *recordingTracer) Trace(event TraceEvent) {
+	tracer.events = append(tracer.events, event)
+}
+
+func TestParserTrace(t *testing.T) {
+	mainLexer := lexer.Create("1 + 2;")
+	mainLexer.Start()
+
+	tracer := &recordingTracer{}
+
+	parser := Create(mainLexer)
+	parser.SetTracer(tracer)
+	parser.Start()
+
+	if len(tracer.events) == 0 {
+		t.Error("parser.Start did not emit trace events")
+		return
+	}
+
+	first := tracer.events[0]
+	last := tracer.events[len(tracer.events)-1]
+
+	if first.Kind != TK_ENTER || first.Rule != "program" || first.Depth != 0 {
+		t.Errorf("first trace event is incorrect %s %s %d", TraceKindLabels[first.Kind], first.Rule, first.Depth)
+	}
+
+	if last.Kind != TK_EXIT || last.Rule != "program" || last.Depth != 0 {
+		t.Errorf("last trace event is incorrect %s %s %d", TraceKindLabels[last.Kind], last.Rule, last.Depth)
+	}
+
+	for _, event := range tracer.events {
+		if event.Kind == TK_ACCEPT && event.Symbol == lexer.LT_PLUS && event.Rule != "term" {
+			t.Errorf("LT_PLUS accepted in rule %s, expected term", event.Rule)
+		}
+	}
+}
+
+func TestParserNumberLiterals(t *testing.T) {
+	program, diagnostics := parse("0xFF_FFu32 + 1.5e-3f32;")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	expression := program.Statements[0].Expression
+
+	lhs := expression.Lhs.Value
+	rhs := expression.Rhs.Value
+
+	if lhs.Type != TYPE_NUMBER || lhs.Integer != 0xFFFF || lhs.Suffix != "u32" {
+		t.Errorf("integer literal is incorrect %d %s", lhs.Integer, lhs.Suffix)
+	}
+
+	if rhs.Type != TYPE_FLOAT || rhs.Float != float64(float32(1.5e-3)) || rhs.Suffix != "f32" {
+		t.Errorf("float literal is incorrect %g %s", rhs.Float, rhs.Suffix)
+	}
+}
+
+func TestParserTypeAnnotations(t *testing.T) {
+	program, diagnostics := parse("const x: u8 = 1;\nval s: string;\nconst f = (a: int, g: (int) => bool, b): f32 => a;")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	if declared := program.Statements[0].Declaration.Type; declared.Type != TYPE_NUMBER || declared.Name != "u8" {
+		t.Errorf("declaration type is incorrect %s", declared)
+	}
+
+	if declaration := program.Statements[1].Declaration; declaration.Type.Type != TYPE_STRING || declaration.Value != nil {
+		t.Errorf("declaration without a value is incorrect %s %v", declaration.Type, declaration.Value)
+	}
+
+	function := program.Statements[2].Declaration.Value.Value.Function
+	params := make([]string, 0)
+
+	for _, param := range function.Props {
+		params = append(params, param.String())
+	}
+
+	if result := strings.Join(params, ", "); result != "a: int, g: (int) => bool, b" {
+		t.Errorf("function parameters are incorrect %s", result)
+	}
+
+	if function.Result.String() != "f32" {
+		t.Errorf("function result is incorrect %s", function.Result)
+	}
+}
+
+func TestParserTypeAnnotationErrors(t *testing.T) {
+	inputs := []string{"const x: = 1;", "val x;", "const f = (a:) => a;"}
+
+	for _, input := range inputs {
+		if _, diagnostics := parse(input); !diagnostic.HasErrors(diagnostics) {
+			t.Errorf("parser.Start did not report an error for %q", input)
+		}
+	}
+}
+
+func TestParserAssignments(t *testing.T) {
+	program, diagnostics := parse("val x = 1;\nconst y = 2;\nx += y * 2;\na.b = 3;")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	if program.Statements[0].Declaration.Kind != BK_VAL || program.Statements[1].Declaration.Kind != BK_CONST {
+		t.Error("parser.Start did not record the binding kinds")
+	}
+
+	assignment := program.Statements[2].Assignment
+
+	if program.Statements[2].SType != ST_ASSIGNMENT || assignment.Operator != lexer.LT_PLUS_EQUALS || assignment.Target.Identifier != "x" || assignment.Value.EType != ET_BINARY {
+		t.Errorf("compound assignment is incorrect %+v", assignment)
+	}
+
+	if program.Statements[3].Assignment.Target.EType != ET_MEMBER_ACCESS {
+		t.Error("member assignment is incorrect")
+	}
+
+	for _, input := range []string{"1 = 2;", "f() = 2;", "a.f() = 1;"} {
+		if _, diagnostics := parse(input); len(diagnostics) == 0 || diagnostics[0].Code != diagnostic.CODE_INVALID_ASSIGNMENT {
+			t.Errorf("parser.Start did not reject the assignment %q", input)
+		}
+	}
+}
+
+func TestParserIfChain(t *testing.T) {
+	program, diagnostics := parse("if (a) {\n 1;\n} elseif (b) {\n 2;\n} else if (c) {\n 3;\n} else {\n 4;\n 5;\n}")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	conditions := make([]string, 0)
+	branch := program.Statements[0].If
+
+	for ; branch.Else != nil; branch = branch.Else {
+		conditions = append(conditions, branch.Condition.Identifier)
+	}
+
+	if strings.Join(conditions, ",") != "a,b,c" {
+		t.Errorf("if chain conditions are incorrect %v", conditions)
+	}
+
+	if branch.Condition != nil || len(branch.Statements) != 2 {
+		t.Errorf("else branch is incorrect %+v", branch)
+	}
+}
+
+func TestParserIfWithoutBraces(t *testing.T) {
+	for _, input := range []string{"if (a) b();", "if (a) {\n} else b();"} {
+		if _, diagnostics := parse(input); len(diagnostics) == 0 || diagnostics[0].Code != diagnostic.CODE_EXPECTED_BLOCK {
+			t.Errorf("parser.Start did not report the missing block in %q", input)
+		}
+	}
+}
+
+func TestParserLoops(t *testing.T) {
+	program, diagnostics := parse("while (a < 3) {\n break;\n}\nfor (x of 10) {\n continue;\n printf(x);\n}")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	while := program.Statements[0]
+
+	if while.SType != ST_WHILE || while.Loop.Condition.EType != ET_BINARY || while.Loop.Statements[0].SType != ST_BREAK {
+		t.Errorf("while loop is incorrect %+v", while.Loop)
+	}
+
+	loop := program.Statements[1]
+
+	if loop.SType != ST_FOR || loop.Loop.Variable.Name != "x" || loop.Loop.Collection.Value.Integer != 10 {
+		t.Errorf("for loop is incorrect %+v", loop.Loop)
+	}
+
+	if len(loop.Loop.Statements) != 2 || loop.Loop.Statements[0].SType != ST_CONTINUE {
+		t.Errorf("for loop body is incorrect %+v", loop.Loop.Statements)
+	}
+}
+
+func TestParserStructs(t *testing.T) {
+	program, diagnostics := parse("struct Point {\n x: int,\n f: (int) => int,\n}\nconst p = Point { x: 1, f: g };\nconst e = Empty {}.x;")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	declaration := program.Statements[0]
+
+	if declaration.SType != ST_STRUCT || declaration.Struct.Name != "Point" || len(declaration.Struct.Fields) != 2 {
+		t.Errorf("struct declaration is incorrect %+v", declaration.Struct)
+	} else if declaration.Struct.Fields[1].String() != "f: (int) => int" {
+		t.Errorf("struct field is incorrect %s", declaration.Struct.Fields[1])
+	}
+
+	literal := program.Statements[1].Declaration.Value
+
+	if literal.EType != ET_VALUE || literal.Value.Type != TYPE_STRUCT || len(literal.Value.Struct.Fields) != 2 {
+		t.Errorf("struct literal is incorrect %+v", literal.Value)
+	} else if field := literal.Value.Struct.Fields[1]; field.Name != "f" || field.Value.Identifier != "g" {
+		t.Errorf("struct literal field is incorrect %+v", field)
+	}
+
+	access := program.Statements[2].Declaration.Value
+
+	if access.EType != ET_MEMBER_ACCESS || access.Value.Struct.Name != "Empty" || access.Rhs.Lhs.Identifier != "x" {
+		t.Errorf("member access on a struct literal is incorrect %+v", access)
+	}
+}
+
+func TestParserInterfaces(t *testing.T) {
+	program, diagnostics := parse("interface Shape {\n area: () => float,\n scale: (float) => void\n}")
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics", len(diagnostics))
+		return
+	}
+
+	declaration := program.Statements[0]
+
+	if declaration.SType != ST_INTERFACE || declaration.Struct.Name != "Shape" || len(declaration.Struct.Fields) != 2 {
+		t.Errorf("interface declaration is incorrect %+v", declaration.Struct)
+	} else if declaration.Struct.Fields[1].String() != "scale: (float) => void" {
+		t.Errorf("interface member is incorrect %s", declaration.Struct.Fields[1])
+	}
+}
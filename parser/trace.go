@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/milansav/Castle/lexer"
+)
+
+type TraceKind int
+
+const (
+	TK_ENTER  TraceKind = iota // Entered grammar rule
+	TK_EXIT                    // Left grammar rule
+	TK_ACCEPT                  // Consumed a symbol
+	TK_EXPECT                  // Required a symbol
+)
+
+var TraceKindLabels = map[TraceKind]string{
+	TK_ENTER:  "TK_ENTER",
+	TK_EXIT:   "TK_EXIT",
+	TK_ACCEPT: "TK_ACCEPT",
+	TK_EXPECT: "TK_EXPECT",
+}
+
+// TraceEvent describes a single step of the parser. Rule is the innermost
+// grammar rule being parsed and Depth the number of rules entered above it.
+// Symbol is only set for TK_ACCEPT and TK_EXPECT, Lexeme is the current
+// lexeme when the event happened (the accepted one for TK_ACCEPT).
+type TraceEvent struct {
+	Kind   TraceKind
+	Rule   string
+	Depth  int
+	Symbol lexer.LexemeType
+	Lexeme lexer.Lexeme
+}
+
+type Tracer interface {
+	Trace(event TraceEvent)
+}
+
+// WriterTracer writes one indented line per event
+type WriterTracer struct {
+	Writer io.Writer
+}
+
+func (tracer WriterTracer) Trace(event TraceEvent) {
+	indentation := strings.Repeat("  ", event.Depth)
+
+	switch event.Kind {
+	case TK_ENTER:
+		fmt.Fprintf(tracer.Writer, "%s> %s (%s)\n", indentation, event.Rule, event.Lexeme.Span().Start)
+	case TK_EXIT:
+		fmt.Fprintf(tracer.Writer, "%s< %s\n", indentation, event.Rule)
+	case TK_ACCEPT:
+		fmt.Fprintf(tracer.Writer, "%s  accept %s\n", indentation, describe(event.Lexeme))
+	case TK_EXPECT:
+		fmt.Fprintf(tracer.Writer, "%s  expect %s\n", indentation, lexer.LexemeTypeLabels[event.Symbol])
+	}
+}
+
+// SetTracer enables tracing of the parser, nil disables it
+func (parser *Parser) SetTracer(tracer Tracer) {
+	parser.tracer = tracer
+}
+
+type traceScope struct {
+	parser *Parser
+	rule   string
+}
+
+// enter and leave bracket a grammar rule, use as defer leave(enter(parser, "rule"))
+func enter(parser *Parser, rule string) traceScope {
+	if parser.tracer != nil {
+		parser.tracer.Trace(TraceEvent{Kind: TK_ENTER, Rule: rule, Depth: len(parser.rules), Lexeme: curr(parser)})
+	}
+
+	parser.rules = append(parser.rules, rule)
+
+	return traceScope{parser: parser, rule: rule}
+}
+
+func leave(scope traceScope) {
+	parser := scope.parser
+	parser.rules = parser.rules[:len(parser.rules)-1]
+
+	if parser.tracer != nil {
+		parser.tracer.Trace(TraceEvent{Kind: TK_EXIT, Rule: scope.rule, Depth: len(parser.rules), Lexeme: curr(parser)})
+	}
+}
+
+func trace(parser *Parser, kind TraceKind, symbol lexer.LexemeType, lexeme lexer.Lexeme) {
+	if parser.tracer == nil {
+		return
+	}
+
+	rule := ""
+	depth := len(parser.rules)
+
+	if depth > 0 {
+		rule = parser.rules[depth-1]
+		depth--
+	}
+
+	parser.tracer.Trace(TraceEvent{Kind: kind, Rule: rule, Depth: depth, Symbol: symbol, Lexeme: lexeme})
+}
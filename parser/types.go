@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"strings"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+)
+
+// AST_Type is the type of a value. Name narrows TYPE_NUMBER and TYPE_FLOAT
+// to a width (u8, i32, f32, ...), empty for the default int and double.
+// Params and Result are only set for TYPE_FUNCTION. Annotations with a name
+// the parser does not know are TYPE_UNDEFINED with that Name until the type
+// checker resolves the names of structs and interfaces to TYPE_STRUCT and
+// TYPE_INTERFACE. Span is only set for annotations.
+type AST_Type struct {
+	Type   ValueType
+	Name   string
+	Params []*AST_Type
+	Result *AST_Type
+	Span   diagnostic.Span
+}
+
+var typeNames = map[ValueType]string{
+	TYPE_UNDEFINED: "undefined",
+	TYPE_STRING:    "string",
+	TYPE_NUMBER:    "int",
+	TYPE_FLOAT:     "float",
+	TYPE_BOOL:      "bool",
+	TYPE_VOID:      "void",
+	TYPE_STRUCT:    "struct",
+	TYPE_INTERFACE: "interface",
+}
+
+// namedTypes are the names of the builtin types in annotations
+var namedTypes = map[string]AST_Type{
+	"int":    {Type: TYPE_NUMBER},
+	"float":  {Type: TYPE_FLOAT},
+	"string": {Type: TYPE_STRING},
+	"bool":   {Type: TYPE_BOOL},
+	"void":   {Type: TYPE_VOID},
+	"u8":     {Type: TYPE_NUMBER, Name: "u8"},
+	"u16":    {Type: TYPE_NUMBER, Name: "u16"},
+	"u32":    {Type: TYPE_NUMBER, Name: "u32"},
+	"u64":    {Type: TYPE_NUMBER, Name: "u64"},
+	"i8":     {Type: TYPE_NUMBER, Name: "i8"},
+	"i16":    {Type: TYPE_NUMBER, Name: "i16"},
+	"i32":    {Type: TYPE_NUMBER, Name: "i32"},
+	"i64":    {Type: TYPE_NUMBER, Name: "i64"},
+	"f32":    {Type: TYPE_FLOAT, Name: "f32"},
+	"f64":    {Type: TYPE_FLOAT, Name: "f64"},
+}
+
+// String returns the type as written in Castle, e.g. "(int, string) => bool"
+func (t *AST_Type) String() string {
+	if t == nil {
+		return "undefined"
+	}
+
+	if t.Type == TYPE_FUNCTION {
+		params := make([]string, 0, len(t.Params))
+
+		for _, param := range t.Params {
+			params = append(params, param.String())
+		}
+
+		return "(" + strings.Join(params, ", ") + ") => " + t.Result.String()
+	}
+
+	if t.Name != "" {
+		return t.Name
+	}
+
+	return typeNames[t.Type]
+}
+
+// String returns the parameter as written in Castle, e.g. "a: int"
+func (param *AST_Param) String() string {
+	if param.Type == nil {
+		return param.Name
+	}
+
+	return param.Name + ": " + param.Type.String()
+}
+
+// String returns the field of a struct declaration as written in Castle, e.g. "x: int"
+func (field *AST_Field) String() string {
+	return field.Name + ": " + field.Type.String()
+}
+
+// ParamNames returns the names of the parameters of the function
+func (function *AST_Function) ParamNames() []string {
+	names := make([]string, 0, len(function.Props))
+
+	for _, param := range function.Props {
+		names = append(names, param.Name)
+	}
+
+	return names
+}
+
+// ExpressionBody returns the expression of a function without a block, e.g.
+// (a) => a + 1, which is the result of the function. It is nil for blocks.
+func (function *AST_Function) ExpressionBody() *AST_Expression {
+	body := function.Statement
+
+	if body.SType == ST_STATEMENT && body.Statement.SType == ST_EXPRESSION {
+		return body.Statement.Expression
+	}
+
+	return nil
+}
+
+// typeAnnotation -> LT_IDENTIFIER | LT_LPAREN (typeAnnotation (LT_COMMA typeAnnotation)*)? LT_RPAREN LT_LAMBDA typeAnnotation
+func typeAnnotation(parser *Parser) *AST_Type {
+	defer leave(enter(parser, "typeAnnotation"))
+
+	start := curr(parser)
+
+	if accept(parser, lexer.LT_IDENTIFIER) {
+		result := &AST_Type{Type: TYPE_UNDEFINED, Name: start.Label, Span: start.Span()}
+
+		if named, ok := namedTypes[start.Label]; ok {
+			result.Type = named.Type
+			result.Name = named.Name
+		}
+
+		return result
+	} else if accept(parser, lexer.LT_LPAREN) { // (params) => result
+		result := &AST_Type{Type: TYPE_FUNCTION, Params: make([]*AST_Type, 0)}
+
+		for {
+			if accept(parser, lexer.LT_RPAREN) || !expectParenEnd(parser) || parser.panicking {
+				break
+			}
+
+			result.Params = append(result.Params, typeAnnotation(parser))
+
+			if !accept(parser, lexer.LT_COMMA) {
+				expect(parser, lexer.LT_RPAREN)
+				break
+			}
+		}
+
+		expect(parser, lexer.LT_LAMBDA)
+
+		result.Result = typeAnnotation(parser)
+		result.Span = spanFrom(parser, start)
+
+		return result
+	}
+
+	report(parser, diagnostic.Error(
+		diagnostic.CODE_EXPECTED_TYPE,
+		curr(parser).Span(),
+		"expected type, found %s",
+		describe(curr(parser))))
+
+	return &AST_Type{Type: TYPE_UNDEFINED, Span: curr(parser).Span()}
+}
@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Node is one of *AST_Program, *AST_Statement, *AST_Expression,
+// *AST_Function, *AST_Declaration, *AST_If, *AST_Assignment, *AST_Loop,
+// *AST_FunctionCall, *AST_Value, *AST_Struct or *AST_Field
+type Node interface{}
+
+// Visitor is called by Walk for every node. When Visit returns nil the
+// children of the node are skipped, otherwise they are walked with the
+// returned visitor.
+type Visitor interface {
+	Visit(node Node) Visitor
+}
+
+// LeaveVisitor is a Visitor that is also told when Walk is done with the
+// children of a node it visited, which gives a post-order traversal
+type LeaveVisitor interface {
+	Visitor
+	Leave(node Node)
+}
+
+// Walk traverses the tree in depth-first order, calling visitor.Visit
+// before the children of a node and Leave after them
+func Walk(visitor Visitor, node Node) {
+	if isNil(node) {
+		return
+	}
+
+	child := visitor.Visit(node)
+
+	if child == nil {
+		return
+	}
+
+	for _, element := range Children(node) {
+		Walk(child, element)
+	}
+
+	if leave, ok := visitor.(LeaveVisitor); ok {
+		leave.Leave(node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect calls f for every node in pre-order, returning false skips the children of the node
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+type postInspector func(Node)
+
+func (f postInspector) Visit(node Node) Visitor {
+	return f
+}
+
+func (f postInspector) Leave(node Node) {
+	f(node)
+}
+
+// InspectPost calls f for every node after its children
+func InspectPost(node Node, f func(Node)) {
+	Walk(postInspector(f), node)
+}
+
+// Children returns the direct children of the node in source order, nodes
+// it does not know have none so new kinds of nodes do not break traversals
+func Children(node Node) []Node {
+	children := make([]Node, 0)
+
+	add := func(child Node) {
+		if !isNil(child) {
+			children = append(children, child)
+		}
+	}
+
+	switch n := node.(type) {
+	case *AST_Program:
+		for _, element := range n.Statements {
+			add(element)
+		}
+	case *AST_Statement:
+		for _, element := range n.Statements {
+			add(element)
+		}
+
+		add(n.Statement)
+		add(n.Expression)
+		add(n.Function)
+		add(n.Declaration)
+		add(n.If)
+		add(n.Assignment)
+		add(n.Loop)
+		add(n.Struct)
+	case *AST_Declaration:
+		add(n.Value)
+	case *AST_If:
+		add(n.Condition)
+
+		for _, element := range n.Statements {
+			add(element)
+		}
+
+		add(n.Else)
+	case *AST_Assignment:
+		add(n.Target)
+		add(n.Value)
+	case *AST_Loop:
+		add(n.Condition)
+		add(n.Variable)
+		add(n.Collection)
+
+		for _, element := range n.Statements {
+			add(element)
+		}
+	case *AST_Function:
+		add(n.Statement)
+	case *AST_Expression:
+		add(n.Lhs)
+		add(n.Value)
+		add(n.FunctionCall)
+		add(n.Rhs)
+	case *AST_FunctionCall:
+		for _, element := range n.Params {
+			add(element)
+		}
+	case *AST_Value:
+		add(n.Function)
+		add(n.Struct)
+	case *AST_Struct:
+		for _, element := range n.Fields {
+			add(element)
+		}
+	case *AST_Field:
+		add(n.Value)
+	default:
+		return nil
+	}
+
+	return children
+}
+
+// Rewrite replaces every node with the result of f, children first. f gets
+// the node with its children already rewritten and returns the node itself,
+// or a replacement of the same type, e.g. an *AST_Expression for an
+// *AST_Expression. Rewrite returns the new root.
+func Rewrite(node Node, f func(Node) Node) Node {
+	if isNil(node) {
+		return node
+	}
+
+	switch n := node.(type) {
+	case *AST_Program:
+		rewriteStatements(n.Statements, f)
+	case *AST_Statement:
+		rewriteStatements(n.Statements, f)
+		n.Statement = rewriteStatement(n.Statement, f)
+		n.Expression = rewriteExpression(n.Expression, f)
+
+		if result := Rewrite(n.Function, f); result != nil {
+			n.Function = result.(*AST_Function)
+		}
+
+		if result := Rewrite(n.Declaration, f); result != nil {
+			n.Declaration = result.(*AST_Declaration)
+		}
+
+		if result := Rewrite(n.If, f); result != nil {
+			n.If = result.(*AST_If)
+		}
+
+		if result := Rewrite(n.Assignment, f); result != nil {
+			n.Assignment = result.(*AST_Assignment)
+		}
+
+		if result := Rewrite(n.Loop, f); result != nil {
+			n.Loop = result.(*AST_Loop)
+		}
+
+		if result := Rewrite(n.Struct, f); result != nil {
+			n.Struct = result.(*AST_Struct)
+		}
+	case *AST_Declaration:
+		n.Value = rewriteExpression(n.Value, f)
+	case *AST_If:
+		n.Condition = rewriteExpression(n.Condition, f)
+		rewriteStatements(n.Statements, f)
+
+		if result := Rewrite(n.Else, f); result != nil {
+			n.Else = result.(*AST_If)
+		}
+	case *AST_Assignment:
+		n.Target = rewriteExpression(n.Target, f)
+		n.Value = rewriteExpression(n.Value, f)
+	case *AST_Loop:
+		n.Condition = rewriteExpression(n.Condition, f)
+
+		if result := Rewrite(n.Variable, f); result != nil {
+			n.Variable = result.(*AST_Declaration)
+		}
+
+		n.Collection = rewriteExpression(n.Collection, f)
+		rewriteStatements(n.Statements, f)
+	case *AST_Function:
+		n.Statement = rewriteStatement(n.Statement, f)
+	case *AST_Expression:
+		n.Lhs = rewriteExpression(n.Lhs, f)
+
+		if result := Rewrite(n.Value, f); result != nil {
+			n.Value = result.(*AST_Value)
+		}
+
+		if result := Rewrite(n.FunctionCall, f); result != nil {
+			n.FunctionCall = result.(*AST_FunctionCall)
+		}
+
+		n.Rhs = rewriteExpression(n.Rhs, f)
+	case *AST_FunctionCall:
+		for index, element := range n.Params {
+			n.Params[index] = rewriteExpression(element, f)
+		}
+	case *AST_Value:
+		if result := Rewrite(n.Function, f); result != nil {
+			n.Function = result.(*AST_Function)
+		}
+
+		if result := Rewrite(n.Struct, f); result != nil {
+			n.Struct = result.(*AST_Struct)
+		}
+	case *AST_Struct:
+		for index, element := range n.Fields {
+			n.Fields[index] = Rewrite(element, f).(*AST_Field)
+		}
+	case *AST_Field:
+		n.Value = rewriteExpression(n.Value, f)
+	}
+
+	result := f(node)
+
+	if reflect.TypeOf(result) != reflect.TypeOf(node) {
+		panic(fmt.Sprintf("parser: Rewrite replaced %T with %T", node, result))
+	}
+
+	return result
+}
+
+func rewriteStatements(statements []*AST_Statement, f func(Node) Node) {
+	for index, element := range statements {
+		statements[index] = rewriteStatement(element, f)
+	}
+}
+
+func rewriteStatement(statement *AST_Statement, f func(Node) Node) *AST_Statement {
+	if statement == nil {
+		return nil
+	}
+
+	return Rewrite(statement, f).(*AST_Statement)
+}
+
+func rewriteExpression(expression *AST_Expression, f func(Node) Node) *AST_Expression {
+	if expression == nil {
+		return nil
+	}
+
+	return Rewrite(expression, f).(*AST_Expression)
+}
+
+// isNil also catches typed nil pointers stored in a Node
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *AST_Program:
+		return n == nil
+	case *AST_Statement:
+		return n == nil
+	case *AST_Expression:
+		return n == nil
+	case *AST_Function:
+		return n == nil
+	case *AST_Declaration:
+		return n == nil
+	case *AST_If:
+		return n == nil
+	case *AST_Assignment:
+		return n == nil
+	case *AST_Loop:
+		return n == nil
+	case *AST_FunctionCall:
+		return n == nil
+	case *AST_Value:
+		return n == nil
+	case *AST_Struct:
+		return n == nil
+	case *AST_Field:
+		return n == nil
+	}
+
+	return false
+}
@@ -0,0 +1,129 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/milansav/Castle/lexer"
+)
+
+func kinds(node Node) string {
+	switch n := node.(type) {
+	case *AST_Statement:
+		return StatementTypeLabels[n.SType]
+	case *AST_Expression:
+		return ExpressionTypeLabels[n.EType]
+	case *AST_Value:
+		return n.Literal
+	case *AST_Program:
+		return "Program"
+	case *AST_Function:
+		return "Function"
+	case *AST_Declaration:
+		return "Declaration"
+	case *AST_If:
+		return "If"
+	case *AST_FunctionCall:
+		return "FunctionCall"
+	}
+
+	return "?"
+}
+
+func TestInspect(t *testing.T) {
+	program, _ := parse("const a = 1 + f(2);\nif (a) {\n return 3;\n}")
+
+	visited := make([]string, 0)
+
+	Inspect(program, func(node Node) bool {
+		visited = append(visited, kinds(node))
+
+		// Call arguments are skipped
+		_, call := node.(*AST_FunctionCall)
+
+		return !call
+	})
+
+	expected := "Program ST_DECLARATION Declaration ET_BINARY ET_LITERAL 1 ET_FUNCTION_CALL FunctionCall ST_IF If ET_IDENTIFIER ST_RETURN ET_LITERAL 3"
+
+	if output := strings.Join(visited, " "); output != expected {
+		t.Errorf("parser.Inspect order is incorrect.\nExpected %s\ngot      %s", expected, output)
+	}
+}
+
+func TestInspectPost(t *testing.T) {
+	program, _ := parse("1 * 2;")
+
+	visited := make([]string, 0)
+
+	InspectPost(program, func(node Node) {
+		visited = append(visited, kinds(node))
+	})
+
+	expected := "1 ET_LITERAL 2 ET_LITERAL ET_BINARY ST_EXPRESSION Program"
+
+	if output := strings.Join(visited, " "); output != expected {
+		t.Errorf("parser.InspectPost order is incorrect.\nExpected %s\ngot      %s", expected, output)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	program, _ := parse("const a = 3 * (1 + 2);")
+
+	// Fold additions of two literals
+	Rewrite(program, func(node Node) Node {
+		expression, ok := node.(*AST_Expression)
+
+		if !ok || expression.EType != ET_BINARY || expression.Operator != lexer.LT_PLUS {
+			return node
+		}
+
+		if expression.Lhs.EType != ET_VALUE || expression.Rhs.EType != ET_VALUE {
+			return node
+		}
+
+		sum := expression.Lhs.Value.Integer + expression.Rhs.Value.Integer
+
+		return &AST_Expression{
+			EType: ET_VALUE,
+			Value: &AST_Value{Type: TYPE_NUMBER, Integer: sum, Literal: "3"},
+		}
+	})
+
+	group := program.Statements[0].Declaration.Value.Rhs
+
+	if group.EType != ET_GROUP || group.Lhs.EType != ET_VALUE || group.Lhs.Value.Integer != 3 {
+		t.Errorf("parser.Rewrite did not replace the addition %+v", group.Lhs)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("parser.Rewrite accepted a replacement of another type")
+		}
+	}()
+
+	Rewrite(program, func(node Node) Node {
+		if _, ok := node.(*AST_Expression); ok {
+			return &AST_Statement{}
+		}
+
+		return node
+	})
+}
+
+func TestChildrenUnknownNode(t *testing.T) {
+	if children := Children(&AST_Param{Name: "a"}); len(children) != 0 {
+		t.Errorf("parser.Children returned children of an unknown node %v", children)
+	}
+
+	visited := 0
+
+	Inspect(&AST_Param{Name: "a"}, func(node Node) bool {
+		visited++
+		return true
+	})
+
+	if visited != 1 {
+		t.Errorf("parser.Inspect visited %d nodes of an unknown node, expected 1", visited)
+	}
+}
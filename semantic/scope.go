@@ -0,0 +1,75 @@
+package semantic
+
+import (
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/parser"
+)
+
+type SymbolKind int
+
+const (
+	SK_DECLARATION SymbolKind = iota // const or val
+	SK_FUNCTION                      // const bound to a function
+	SK_PARAMETER                     // Function parameter
+	SK_BUILTIN                       // Provided by the C library
+	SK_STRUCT                        // Struct declaration
+	SK_INTERFACE                     // Interface declaration
+)
+
+var SymbolKindLabels = map[SymbolKind]string{
+	SK_DECLARATION: "SK_DECLARATION",
+	SK_FUNCTION:    "SK_FUNCTION",
+	SK_PARAMETER:   "SK_PARAMETER",
+	SK_BUILTIN:     "SK_BUILTIN",
+	SK_STRUCT:      "SK_STRUCT",
+	SK_INTERFACE:   "SK_INTERFACE",
+}
+
+// Symbol is a declared name. Declaration is nil for parameters and builtins,
+// Function is the function of SK_FUNCTION symbols and the function
+// declaring SK_PARAMETER symbols. Struct is the declaration of SK_STRUCT and
+// SK_INTERFACE symbols.
+type Symbol struct {
+	Name        string
+	Kind        SymbolKind
+	Span        diagnostic.Span
+	Declaration *parser.AST_Declaration
+	Function    *parser.AST_Function
+	Struct      *parser.AST_Struct
+}
+
+type Scope struct {
+	Parent  *Scope
+	Symbols map[string]*Symbol
+}
+
+func createScope(parent *Scope) *Scope {
+	return &Scope{
+		Parent:  parent,
+		Symbols: make(map[string]*Symbol),
+	}
+}
+
+// Lookup finds the symbol in this scope or the enclosing ones
+func (scope *Scope) Lookup(name string) *Symbol {
+	for current := scope; current != nil; current = current.Parent {
+		if symbol, ok := current.Symbols[name]; ok {
+			return symbol
+		}
+	}
+
+	return nil
+}
+
+// Builtins are the C functions programs may call without declaring them
+var Builtins = []string{"printf", "puts", "putchar"}
+
+func builtinScope() *Scope {
+	scope := createScope(nil)
+
+	for _, name := range Builtins {
+		scope.Symbols[name] = &Symbol{Name: name, Kind: SK_BUILTIN}
+	}
+
+	return scope
+}
@@ -0,0 +1,381 @@
+// Package semantic resolves the names of a parsed program. The program, every
+// function body and every if or loop block get their own scope. Functions,
+// structs and interfaces are hoisted within their scope, other declarations
+// are visible after them.
+package semantic
+
+import (
+	"sort"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/parser"
+)
+
+type Analyzer struct {
+	Program     *parser.AST_Program
+	Diagnostics []diagnostic.Diagnostic
+	// The symbol every ET_IDENTIFIER and ET_FUNCTION_CALL refers to, the
+	// root of member accesses on a name and the struct of struct literals
+	Bindings map[*parser.AST_Expression]*Symbol
+	// Scope of the top level declarations, its parent holds the builtins
+	Global *Scope
+	scope  *Scope
+	// Number of loops around the current statement within its function
+	loops int
+}
+
+func Create(program *parser.AST_Program) Analyzer {
+	global := createScope(builtinScope())
+
+	return Analyzer{
+		Program:  program,
+		Bindings: make(map[*parser.AST_Expression]*Symbol),
+		Global:   global,
+		scope:    global,
+	}
+}
+
+func (analyzer *Analyzer) Start() []diagnostic.Diagnostic {
+	analyzer.statements(analyzer.Program.Statements)
+
+	// Hoisting reports out of source order
+	sort.SliceStable(analyzer.Diagnostics, func(i, j int) bool {
+		return analyzer.Diagnostics[i].Span.Start.Offset < analyzer.Diagnostics[j].Span.Start.Offset
+	})
+
+	return analyzer.Diagnostics
+}
+
+func (analyzer *Analyzer) report(d diagnostic.Diagnostic) {
+	analyzer.Diagnostics = append(analyzer.Diagnostics, d)
+}
+
+func (analyzer *Analyzer) enterScope() {
+	analyzer.scope = createScope(analyzer.scope)
+}
+
+func (analyzer *Analyzer) leaveScope() {
+	analyzer.scope = analyzer.scope.Parent
+}
+
+// declare adds the symbol to the current scope, redeclarations in the same
+// scope are errors and hiding a name of an enclosing scope is a warning
+func (analyzer *Analyzer) declare(symbol *Symbol) {
+	if previous, ok := analyzer.scope.Symbols[symbol.Name]; ok {
+		analyzer.report(diagnostic.Error(
+			diagnostic.CODE_DUPLICATE_NAME,
+			symbol.Span,
+			"%s is already declared in this scope", symbol.Name).
+			WithNote("previous declaration at %s", previous.Span.Start))
+
+		return
+	}
+
+	if shadowed := analyzer.scope.Parent.Lookup(symbol.Name); shadowed != nil {
+		warning := diagnostic.Warning(
+			diagnostic.CODE_SHADOWED_NAME,
+			symbol.Span,
+			"%s shadows an outer declaration", symbol.Name)
+
+		if shadowed.Kind == SK_BUILTIN {
+			warning = warning.WithNote("%s is a builtin", symbol.Name)
+		} else {
+			warning = warning.WithNote("outer declaration at %s", shadowed.Span.Start)
+		}
+
+		analyzer.report(warning)
+	}
+
+	analyzer.scope.Symbols[symbol.Name] = symbol
+}
+
+// functionOf returns the function a declaration binds, if any
+func functionOf(declaration *parser.AST_Declaration) *parser.AST_Function {
+	value := declaration.Value
+
+	if value == nil || value.EType != parser.ET_VALUE || value.Value.Type != parser.TYPE_FUNCTION {
+		return nil
+	}
+
+	return value.Value.Function
+}
+
+func (analyzer *Analyzer) statements(statements []*parser.AST_Statement) {
+	// Functions, structs and interfaces are hoisted so they can refer to each other
+	for _, statement := range statements {
+		if statement.SType == parser.ST_STRUCT || statement.SType == parser.ST_INTERFACE {
+			kind := SK_STRUCT
+
+			if statement.SType == parser.ST_INTERFACE {
+				kind = SK_INTERFACE
+			}
+
+			analyzer.declare(&Symbol{
+				Name:   statement.Struct.Name,
+				Kind:   kind,
+				Span:   statement.Struct.Span,
+				Struct: statement.Struct,
+			})
+
+			continue
+		}
+
+		if statement.SType == parser.ST_DECLARATION {
+			if function := functionOf(statement.Declaration); function != nil {
+				analyzer.declare(&Symbol{
+					Name:        statement.Declaration.Name,
+					Kind:        SK_FUNCTION,
+					Span:        statement.Declaration.Span,
+					Declaration: statement.Declaration,
+					Function:    function,
+				})
+			}
+		} else if statement.SType == parser.ST_FUNCTION {
+			analyzer.declare(&Symbol{
+				Name:     statement.Function.Name,
+				Kind:     SK_FUNCTION,
+				Span:     statement.Function.Span,
+				Function: statement.Function,
+			})
+		}
+	}
+
+	for _, statement := range statements {
+		analyzer.statement(statement)
+	}
+}
+
+func (analyzer *Analyzer) statement(statement *parser.AST_Statement) {
+	switch statement.SType {
+	case parser.ST_STATEMENT_ARRAY:
+		analyzer.statements(statement.Statements)
+	case parser.ST_STATEMENT:
+		analyzer.statement(statement.Statement)
+	case parser.ST_EXPRESSION, parser.ST_RETURN:
+		analyzer.expression(statement.Expression)
+	case parser.ST_FUNCTION:
+		analyzer.function(statement.Function)
+	case parser.ST_DECLARATION:
+		declaration := statement.Declaration
+
+		if function := functionOf(declaration); function != nil {
+			analyzer.function(function)
+			return
+		}
+
+		if declaration.Value == nil && declaration.Kind == parser.BK_CONST {
+			analyzer.report(diagnostic.Error(
+				diagnostic.CODE_UNINITIALIZED,
+				declaration.Span,
+				"const %s must be initialized", declaration.Name))
+		}
+
+		// The declared name is not visible in its own initializer
+		analyzer.expression(declaration.Value)
+
+		analyzer.declare(&Symbol{
+			Name:        declaration.Name,
+			Kind:        SK_DECLARATION,
+			Span:        declaration.Span,
+			Declaration: declaration,
+		})
+	case parser.ST_STRUCT, parser.ST_INTERFACE:
+		analyzer.fields(statement.Struct)
+	case parser.ST_ASSIGNMENT:
+		analyzer.expression(statement.Assignment.Value)
+		analyzer.expression(statement.Assignment.Target)
+		analyzer.assignment(statement.Assignment)
+	case parser.ST_IF:
+		// Every branch has its own scope
+		for branch := statement.If; branch != nil; branch = branch.Else {
+			analyzer.expression(branch.Condition)
+
+			analyzer.enterScope()
+			analyzer.statements(branch.Statements)
+			analyzer.leaveScope()
+		}
+	case parser.ST_WHILE, parser.ST_FOR:
+		loop := statement.Loop
+
+		analyzer.expression(loop.Condition)
+		analyzer.expression(loop.Collection)
+
+		analyzer.enterScope()
+		defer analyzer.leaveScope()
+
+		// The loop variable is a const scoped to the body
+		if loop.Variable != nil {
+			analyzer.declare(&Symbol{
+				Name:        loop.Variable.Name,
+				Kind:        SK_DECLARATION,
+				Span:        loop.Variable.Span,
+				Declaration: loop.Variable,
+			})
+		}
+
+		analyzer.loops++
+		analyzer.statements(loop.Statements)
+		analyzer.loops--
+	case parser.ST_BREAK, parser.ST_CONTINUE:
+		if analyzer.loops == 0 {
+			keyword := "break"
+
+			if statement.SType == parser.ST_CONTINUE {
+				keyword = "continue"
+			}
+
+			analyzer.report(diagnostic.Error(
+				diagnostic.CODE_OUTSIDE_LOOP,
+				statement.Span,
+				"%s outside of a loop", keyword))
+		}
+	}
+}
+
+// assignment rejects assigning to names that cannot change, only val
+// declarations and parameters can. Members of a const can be assigned.
+func (analyzer *Analyzer) assignment(assignment *parser.AST_Assignment) {
+	symbol, ok := analyzer.Bindings[assignment.Target]
+
+	if !ok || assignment.Target.EType != parser.ET_IDENTIFIER {
+		return
+	}
+
+	switch {
+	case symbol.Kind == SK_DECLARATION && symbol.Declaration.Kind == parser.BK_CONST:
+		analyzer.report(diagnostic.Error(
+			diagnostic.CODE_ASSIGN_CONST,
+			assignment.Target.Span,
+			"cannot assign to %s, it is a const", symbol.Name).
+			WithNote("declared at %s, use val to allow assignments", symbol.Span.Start))
+	case symbol.Kind == SK_FUNCTION:
+		analyzer.report(diagnostic.Error(
+			diagnostic.CODE_ASSIGN_CONST,
+			assignment.Target.Span,
+			"cannot assign to %s, it is a function", symbol.Name).
+			WithNote("declared at %s", symbol.Span.Start))
+	case symbol.Kind == SK_BUILTIN:
+		analyzer.report(diagnostic.Error(
+			diagnostic.CODE_ASSIGN_CONST,
+			assignment.Target.Span,
+			"cannot assign to %s, it is a builtin", symbol.Name))
+	}
+}
+
+// fields reports fields of a struct declaration or literal that are set twice
+func (analyzer *Analyzer) fields(structure *parser.AST_Struct) {
+	seen := make(map[string]*parser.AST_Field)
+
+	for _, field := range structure.Fields {
+		if previous, ok := seen[field.Name]; ok {
+			analyzer.report(diagnostic.Error(
+				diagnostic.CODE_DUPLICATE_NAME,
+				field.Span,
+				"%s.%s is already declared", structure.Name, field.Name).
+				WithNote("previous declaration at %s", previous.Span.Start))
+
+			continue
+		}
+
+		seen[field.Name] = field
+	}
+}
+
+func (analyzer *Analyzer) function(function *parser.AST_Function) {
+	analyzer.enterScope()
+	defer analyzer.leaveScope()
+
+	// Loops around the declaration do not continue into the body
+	loops := analyzer.loops
+	analyzer.loops = 0
+	defer func() { analyzer.loops = loops }()
+
+	for _, param := range function.Props {
+		analyzer.declare(&Symbol{
+			Name:     param.Name,
+			Kind:     SK_PARAMETER,
+			Span:     param.Span,
+			Function: function,
+		})
+	}
+
+	// The body shares the scope of the parameters
+	analyzer.statement(function.Statement)
+}
+
+func (analyzer *Analyzer) expression(expression *parser.AST_Expression) {
+	parser.Inspect(expression, func(node parser.Node) bool {
+		switch n := node.(type) {
+		case *parser.AST_Function:
+			analyzer.function(n)
+			return false
+		case *parser.AST_Expression:
+			switch n.EType {
+			case parser.ET_IDENTIFIER:
+				analyzer.resolve(n, n.Identifier)
+			case parser.ET_FUNCTION_CALL:
+				analyzer.resolve(n, n.FunctionCall.Name)
+			case parser.ET_VALUE:
+				if n.Value.Struct != nil {
+					analyzer.resolve(n, n.Value.Struct.Name)
+					analyzer.fields(n.Value.Struct)
+				}
+			case parser.ET_MEMBER_ACCESS:
+				analyzer.memberAccess(n)
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// memberAccess resolves the root of a.b.c, the member names are not
+// resolved but the arguments of member calls are
+func (analyzer *Analyzer) memberAccess(expression *parser.AST_Expression) {
+	if expression.FunctionCall != nil {
+		analyzer.resolve(expression, expression.FunctionCall.Name)
+	} else if expression.Value != nil && expression.Value.Struct != nil {
+		analyzer.resolve(expression, expression.Value.Struct.Name)
+		analyzer.fields(expression.Value.Struct)
+
+		for _, field := range expression.Value.Struct.Fields {
+			analyzer.expression(field.Value)
+		}
+	} else if expression.Identifier != "" {
+		analyzer.resolve(expression, expression.Identifier)
+	}
+
+	if expression.Lhs != nil {
+		analyzer.expression(expression.Lhs)
+	}
+
+	if expression.FunctionCall != nil {
+		for _, param := range expression.FunctionCall.Params {
+			analyzer.expression(param)
+		}
+	}
+
+	for member := expression.Rhs; member != nil; member = member.Rhs {
+		if member.Lhs != nil && member.Lhs.FunctionCall != nil {
+			for _, param := range member.Lhs.FunctionCall.Params {
+				analyzer.expression(param)
+			}
+		}
+	}
+}
+
+func (analyzer *Analyzer) resolve(expression *parser.AST_Expression, name string) {
+	symbol := analyzer.scope.Lookup(name)
+
+	if symbol == nil {
+		analyzer.report(diagnostic.Error(
+			diagnostic.CODE_UNDEFINED_NAME,
+			expression.Span,
+			"undefined: %s", name))
+
+		return
+	}
+
+	analyzer.Bindings[expression] = symbol
+}
@@ -0,0 +1,116 @@
+package semantic
+
+import (
+	"testing"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+)
+
+func analyze(t *testing.T, input string) (*parser.AST_Program, Analyzer, []diagnostic.Diagnostic) {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, diagnostics := mainParser.Start()
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics for %q", len(diagnostics), input)
+	}
+
+	analyzer := Create(program)
+	diagnostics = analyzer.Start()
+
+	return program, analyzer, diagnostics
+}
+
+func codes(diagnostics []diagnostic.Diagnostic) []string {
+	result := make([]string, 0)
+
+	for _, element := range diagnostics {
+		result = append(result, element.Code)
+	}
+
+	return result
+}
+
+func TestAnalyzerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"const a = 1;\nconst b = a + 1;", []string{}},
+		{"const a = b;", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"const a = a;", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"const a = 1;\nconst a = 2;", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const f = () => 1;\nconst f = () => 2;", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const a = 1;\nconst f = (a) => a;", []string{diagnostic.CODE_SHADOWED_NAME}},
+		{"const f = (a, a) => a;", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const f = (a) => {\n const a = 1;\n};", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const a = 1;\nif (a) {\n const a = 2;\n}\nconst b = a;", []string{diagnostic.CODE_SHADOWED_NAME}},
+		{"if (1) {\n const a = 2;\n}\nconst b = a;", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"const f = () => g();\nconst g = () => f();", []string{}},
+		{"printf(\"%d\", 1);\nmissing(1);", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"const printf = () => 1;", []string{diagnostic.CODE_SHADOWED_NAME}},
+		{"const a = 1;\na.b.c(d);", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"val a = 1;\na = 2;\na += 1;", []string{}},
+		{"const a = 1;\na = 2;", []string{diagnostic.CODE_ASSIGN_CONST}},
+		{"const f = () => 1;\nf = 2;", []string{diagnostic.CODE_ASSIGN_CONST}},
+		{"const f = (a) => {\n a = 2;\n};", []string{}},
+		{"b = 1;", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"const a: int;\nval b: int;", []string{diagnostic.CODE_UNINITIALIZED}},
+		{"val a = 0;\nwhile (a < 3) {\n a += 1;\n if (a == 2) {\n  break;\n }\n}", []string{}},
+		{"for (x of 3) {\n printf(\"%d\", x);\n}\nconst b = x;", []string{diagnostic.CODE_UNDEFINED_NAME}},
+		{"for (x of 3) {\n x = 1;\n}", []string{diagnostic.CODE_ASSIGN_CONST}},
+		{"break;\nif (1) {\n continue;\n}", []string{diagnostic.CODE_OUTSIDE_LOOP, diagnostic.CODE_OUTSIDE_LOOP}},
+		{"while (1) {\n const f = () => {\n  break;\n };\n}", []string{diagnostic.CODE_OUTSIDE_LOOP}},
+		{"const p = Point { x: 1 };\nstruct Point {\n x: int,\n}", []string{}},
+		{"struct Point {\n x: int,\n x: int,\n}", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const p = Point { x: 1, x: 2 };\nstruct Point {\n x: int,\n}", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const f = (s: Shape) => s;\ninterface Shape {\n area: () => int,\n area: () => int,\n}", []string{diagnostic.CODE_DUPLICATE_NAME}},
+		{"const p = Point { x: y };", []string{diagnostic.CODE_UNDEFINED_NAME, diagnostic.CODE_UNDEFINED_NAME}},
+	}
+
+	for _, test := range tests {
+		_, _, diagnostics := analyze(t, test.input)
+		result := codes(diagnostics)
+
+		if len(result) != len(test.expected) {
+			t.Errorf("Analyzer.Start reported %v for %q, expected %v", result, test.input, test.expected)
+			continue
+		}
+
+		for index := range result {
+			if result[index] != test.expected[index] {
+				t.Errorf("Analyzer.Start reported %v for %q, expected %v", result, test.input, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestAnalyzerBindings(t *testing.T) {
+	program, analyzer, diagnostics := analyze(t, "const a = 1;\nconst f = (a) => a + g();\nconst g = () => a;")
+
+	if len(diagnostics) != 1 || diagnostics[0].Code != diagnostic.CODE_SHADOWED_NAME {
+		t.Errorf("Analyzer.Start diagnostics are incorrect %v", codes(diagnostics))
+		return
+	}
+
+	body := program.Statements[1].Declaration.Value.Value.Function.Statement.Statement.Expression
+
+	if symbol := analyzer.Bindings[body.Lhs]; symbol == nil || symbol.Kind != SK_PARAMETER {
+		t.Errorf("Analyzer bound a in f to %+v, expected the parameter", symbol)
+	}
+
+	if symbol := analyzer.Bindings[body.Rhs]; symbol == nil || symbol.Kind != SK_FUNCTION || symbol.Name != "g" {
+		t.Errorf("Analyzer bound g() to %+v, expected the function g", symbol)
+	}
+
+	global := program.Statements[2].Declaration.Value.Value.Function.Statement.Statement.Expression
+
+	if symbol := analyzer.Bindings[global]; symbol == nil || symbol.Declaration != program.Statements[0].Declaration {
+		t.Errorf("Analyzer bound a in g to %+v, expected the global", symbol)
+	}
+}
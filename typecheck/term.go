@@ -0,0 +1,144 @@
+package typecheck
+
+import "github.com/milansav/Castle/parser"
+
+// term is a type while it is being inferred. Type variables have Type
+// TYPE_UNDEFINED and are bound to another term once they are known.
+type term struct {
+	Type   parser.ValueType
+	Name   string
+	Params []*term
+	Result *term
+	bound  *term
+	// Type variables used with arithmetic operators only bind to numbers
+	numeric bool
+}
+
+func variable() *term {
+	return &term{Type: parser.TYPE_UNDEFINED}
+}
+
+func primitive(t parser.ValueType, name string) *term {
+	return &term{Type: t, Name: name}
+}
+
+func (t *term) isVariable() bool {
+	return t.Type == parser.TYPE_UNDEFINED
+}
+
+func (t *term) isNumeric() bool {
+	return t.Type == parser.TYPE_NUMBER || t.Type == parser.TYPE_FLOAT
+}
+
+// find returns the term a chain of bound variables ends in
+func find(t *term) *term {
+	for t.bound != nil {
+		t = t.bound
+	}
+
+	return t
+}
+
+// occurs reports whether the variable appears in t, binding it would create an infinite type
+func occurs(variable *term, t *term) bool {
+	t = find(t)
+
+	if t == variable {
+		return true
+	}
+
+	for _, param := range t.Params {
+		if occurs(variable, param) {
+			return true
+		}
+	}
+
+	return t.Result != nil && occurs(variable, t.Result)
+}
+
+// unify makes both terms the same type, it returns false when they cannot be.
+// Numbers of different width unify, an unsuffixed literal fits any of them.
+func unify(a *term, b *term) bool {
+	a, b = find(a), find(b)
+
+	if a == b {
+		return true
+	}
+
+	if !a.isVariable() && b.isVariable() {
+		a, b = b, a
+	}
+
+	if a.isVariable() {
+		if occurs(a, b) {
+			return false
+		}
+
+		if b.isVariable() {
+			b.numeric = b.numeric || a.numeric
+		} else if a.numeric && !b.isNumeric() {
+			return false
+		}
+
+		a.bound = b
+
+		return true
+	}
+
+	if a.Type != b.Type {
+		return false
+	}
+
+	if a.Type == parser.TYPE_FUNCTION {
+		if len(a.Params) != len(b.Params) {
+			return false
+		}
+
+		for index := range a.Params {
+			if !unify(a.Params[index], b.Params[index]) {
+				return false
+			}
+		}
+
+		return unify(a.Result, b.Result)
+	}
+
+	return a.Name == "" || b.Name == "" || a.Name == b.Name
+}
+
+// assign unifies the type of a value with the type it is stored as, where
+// ints convert to floats like in C
+func assign(target *term, value *term) bool {
+	if find(target).Type == parser.TYPE_FLOAT && find(value).Type == parser.TYPE_NUMBER {
+		return true
+	}
+
+	return unify(target, value)
+}
+
+// export converts the term to a parser.AST_Type, unknown types default to int
+func export(t *term) *parser.AST_Type {
+	t = find(t)
+
+	if t.isVariable() {
+		return &parser.AST_Type{Type: parser.TYPE_NUMBER}
+	}
+
+	result := &parser.AST_Type{Type: t.Type, Name: t.Name}
+
+	if t.Type == parser.TYPE_FUNCTION {
+		result.Params = make([]*parser.AST_Type, 0, len(t.Params))
+
+		for _, param := range t.Params {
+			result.Params = append(result.Params, export(param))
+		}
+
+		result.Result = export(t.Result)
+	}
+
+	return result
+}
+
+func (t *term) String() string {
+	return export(t).String()
+}
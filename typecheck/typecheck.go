@@ -0,0 +1,947 @@
+// Package typecheck infers the type of every expression, declaration and
+// function of a program whose names have been resolved by package semantic.
+// Types are inferred by unification, so parameters take the types of the
+// arguments and operators they are used with. Types nothing constrains
+// default to int. After Start every expression has its Type set, except
+// member accesses on values of unknown type, and every function its
+// parameter and result types. Annotations naming a struct or an interface
+// are resolved to TYPE_STRUCT or TYPE_INTERFACE. Structs are used as the
+// interfaces they satisfy structurally, by having a field of the same type
+// for every member of the interface.
+package typecheck
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+	"github.com/milansav/Castle/semantic"
+)
+
+type Checker struct {
+	Program     *parser.AST_Program
+	Diagnostics []diagnostic.Diagnostic
+	bindings    map[*parser.AST_Expression]*semantic.Symbol
+	expressions map[*parser.AST_Expression]*term
+	// Members are not typed yet, their expressions are left without a type
+	members      map[*term]bool
+	declarations map[*parser.AST_Declaration]*term
+	functions    map[*parser.AST_Function]*term
+	checked      map[*parser.AST_Function]bool
+	// Struct and interface declarations by name and the types of their fields
+	structs    map[string]*parser.AST_Struct
+	interfaces map[string]*parser.AST_Struct
+	fields     map[*parser.AST_Struct]map[string]*term
+	// Struct values used as an interface
+	conversions map[*parser.AST_Expression]*term
+	// Arithmetic over operands whose types are not known yet
+	pending []operation
+	// The function whose body is being checked, nil at the top level
+	frame *frame
+}
+
+type frame struct {
+	function *parser.AST_Function
+	returned bool
+}
+
+type operation struct {
+	expression *parser.AST_Expression
+	lhs        *term
+	rhs        *term
+	result     *term
+}
+
+func Create(program *parser.AST_Program, bindings map[*parser.AST_Expression]*semantic.Symbol) Checker {
+	structs := make(map[string]*parser.AST_Struct)
+	interfaces := make(map[string]*parser.AST_Struct)
+
+	parser.Inspect(program, func(node parser.Node) bool {
+		statement, ok := node.(*parser.AST_Statement)
+
+		if !ok {
+			return true
+		}
+
+		declarations := map[parser.StatementType]map[string]*parser.AST_Struct{
+			parser.ST_STRUCT:    structs,
+			parser.ST_INTERFACE: interfaces,
+		}
+
+		if declared, ok := declarations[statement.SType]; ok {
+			if _, ok := declared[statement.Struct.Name]; !ok {
+				declared[statement.Struct.Name] = statement.Struct
+			}
+		}
+
+		return true
+	})
+
+	return Checker{
+		Program:      program,
+		bindings:     bindings,
+		expressions:  make(map[*parser.AST_Expression]*term),
+		members:      make(map[*term]bool),
+		declarations: make(map[*parser.AST_Declaration]*term),
+		functions:    make(map[*parser.AST_Function]*term),
+		checked:      make(map[*parser.AST_Function]bool),
+		structs:      structs,
+		interfaces:   interfaces,
+		fields:       make(map[*parser.AST_Struct]map[string]*term),
+		conversions:  make(map[*parser.AST_Expression]*term),
+	}
+}
+
+func (checker *Checker) Start() []diagnostic.Diagnostic {
+	checker.statements(checker.Program.Statements)
+	checker.solve()
+	checker.annotate()
+
+	// Calls to hoisted functions report out of source order
+	sort.SliceStable(checker.Diagnostics, func(i, j int) bool {
+		return checker.Diagnostics[i].Span.Start.Offset < checker.Diagnostics[j].Span.Start.Offset
+	})
+
+	return checker.Diagnostics
+}
+
+func (checker *Checker) report(d diagnostic.Diagnostic) {
+	checker.Diagnostics = append(checker.Diagnostics, d)
+}
+
+func (checker *Checker) mismatch(span diagnostic.Span, expected *term, found *term) {
+	checker.report(diagnostic.Error(
+		diagnostic.CODE_TYPE_MISMATCH,
+		span,
+		"mismatched types %s and %s", expected, found))
+}
+
+// solve types the pending arithmetic once its operands are known, operands
+// nothing constrains are ints
+func (checker *Checker) solve() {
+	for len(checker.pending) > 0 {
+		remaining := make([]operation, 0)
+
+		for _, operation := range checker.pending {
+			lhs, rhs := find(operation.lhs), find(operation.rhs)
+
+			if lhs.isVariable() || rhs.isVariable() {
+				remaining = append(remaining, operation)
+				continue
+			}
+
+			if t := checker.widen(operation.expression, lhs, rhs); !unify(operation.result, t) {
+				checker.mismatch(operation.expression.Span, operation.result, t)
+			}
+		}
+
+		if len(remaining) == len(checker.pending) {
+			checker.defaultOperands(remaining)
+		}
+
+		checker.pending = remaining
+	}
+}
+
+// defaultOperands makes the unknown operands ints, except the results of
+// other pending operations which may still turn out to be floats
+func (checker *Checker) defaultOperands(operations []operation) {
+	results := make(map[*term]bool)
+
+	for _, operation := range operations {
+		results[find(operation.result)] = true
+	}
+
+	changed := false
+
+	for _, operation := range operations {
+		for _, operand := range []*term{find(operation.lhs), find(operation.rhs)} {
+			if operand.isVariable() && !results[operand] {
+				unify(operand, primitive(parser.TYPE_NUMBER, ""))
+				changed = true
+			}
+		}
+	}
+
+	if changed {
+		return
+	}
+
+	for _, operation := range operations {
+		unify(operation.lhs, primitive(parser.TYPE_NUMBER, ""))
+		unify(operation.rhs, primitive(parser.TYPE_NUMBER, ""))
+	}
+}
+
+// annotate writes the inferred types into the AST
+func (checker *Checker) annotate() {
+	for expression, t := range checker.expressions {
+		if find(t).isVariable() && checker.members[find(t)] {
+			continue
+		}
+
+		expression.Type = export(t)
+	}
+
+	for function, t := range checker.functions {
+		function.Type = export(t)
+	}
+
+	for expression, t := range checker.conversions {
+		expression.Interface = export(t)
+	}
+}
+
+func (checker *Checker) declarationType(declaration *parser.AST_Declaration) *term {
+	if t, ok := checker.declarations[declaration]; ok {
+		return t
+	}
+
+	t := checker.annotation(declaration.Type)
+	checker.declarations[declaration] = t
+
+	return t
+}
+
+// annotation returns the type of an annotation, or a type variable without one
+func (checker *Checker) annotation(annotation *parser.AST_Type) *term {
+	if annotation == nil {
+		return variable()
+	}
+
+	switch annotation.Type {
+	case parser.TYPE_UNDEFINED:
+		if _, ok := checker.structs[annotation.Name]; ok {
+			annotation.Type = parser.TYPE_STRUCT
+
+			return primitive(parser.TYPE_STRUCT, annotation.Name)
+		}
+
+		if _, ok := checker.interfaces[annotation.Name]; ok {
+			annotation.Type = parser.TYPE_INTERFACE
+
+			return primitive(parser.TYPE_INTERFACE, annotation.Name)
+		}
+
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_UNKNOWN_TYPE,
+			annotation.Span,
+			"unknown type %s", annotation.Name))
+
+		return variable()
+	case parser.TYPE_FUNCTION:
+		t := &term{Type: parser.TYPE_FUNCTION, Params: make([]*term, 0, len(annotation.Params))}
+
+		for _, param := range annotation.Params {
+			t.Params = append(t.Params, checker.annotation(param))
+		}
+
+		t.Result = checker.annotation(annotation.Result)
+
+		return t
+	}
+
+	return primitive(annotation.Type, annotation.Name)
+}
+
+// fieldTypes returns the types of the fields of a struct declaration by name
+func (checker *Checker) fieldTypes(definition *parser.AST_Struct) map[string]*term {
+	if fields, ok := checker.fields[definition]; ok {
+		return fields
+	}
+
+	fields := make(map[string]*term)
+
+	for _, field := range definition.Fields {
+		if _, ok := fields[field.Name]; !ok {
+			fields[field.Name] = checker.annotation(field.Type)
+		}
+	}
+
+	checker.fields[definition] = fields
+
+	return fields
+}
+
+// interfaceDeclaration checks that the members of an interface are functions
+func (checker *Checker) interfaceDeclaration(definition *parser.AST_Struct) {
+	members := checker.fieldTypes(definition)
+
+	for _, member := range definition.Fields {
+		if t := find(members[member.Name]); !t.isVariable() && t.Type != parser.TYPE_FUNCTION {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_NOT_CALLABLE,
+				member.Span,
+				"member %s of %s must be a function, it has type %s", member.Name, definition.Name, t))
+		}
+	}
+}
+
+// satisfies returns why the struct does not satisfy the interface, or an
+// empty string when it does
+func (checker *Checker) satisfies(structure *term, contract *term) string {
+	definition := checker.interfaces[contract.Name]
+	members := checker.fieldTypes(definition)
+	fields := checker.fieldTypes(checker.structs[structure.Name])
+
+	for _, declared := range definition.Fields {
+		name, member := declared.Name, members[declared.Name]
+		field, ok := fields[name]
+
+		if !ok {
+			return fmt.Sprintf("it has no field %s", name)
+		}
+
+		if !unify(field, member) {
+			return fmt.Sprintf("%s has type %s, %s requires %s", name, field, contract, member)
+		}
+	}
+
+	return ""
+}
+
+// assign checks storing the value of expression as target, structs convert
+// to the interfaces they satisfy
+func (checker *Checker) assign(target *term, value *term, expression *parser.AST_Expression) bool {
+	if contract, structure := find(target), find(value); contract.Type == parser.TYPE_INTERFACE && structure.Type == parser.TYPE_STRUCT {
+		if reason := checker.satisfies(structure, contract); reason != "" {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_NOT_SATISFIED,
+				expression.Span,
+				"%s does not satisfy %s, %s", structure, contract, reason))
+		} else {
+			checker.conversions[expression] = contract
+		}
+
+		return true
+	}
+
+	return assign(target, value)
+}
+
+// functionType returns the type of the function, which may not have been checked yet
+func (checker *Checker) functionType(function *parser.AST_Function) *term {
+	if t, ok := checker.functions[function]; ok {
+		return t
+	}
+
+	t := &term{Type: parser.TYPE_FUNCTION, Params: make([]*term, 0, len(function.Props)), Result: checker.annotation(function.Result)}
+
+	for _, param := range function.Props {
+		t.Params = append(t.Params, checker.annotation(param.Type))
+	}
+
+	checker.functions[function] = t
+
+	return t
+}
+
+func (checker *Checker) symbolType(symbol *semantic.Symbol) *term {
+	switch symbol.Kind {
+	case semantic.SK_DECLARATION:
+		return checker.declarationType(symbol.Declaration)
+	case semantic.SK_FUNCTION:
+		return checker.functionType(symbol.Function)
+	case semantic.SK_PARAMETER:
+		function := checker.functionType(symbol.Function)
+
+		for index, param := range symbol.Function.Props {
+			if param.Name == symbol.Name {
+				return function.Params[index]
+			}
+		}
+	}
+
+	return variable()
+}
+
+func (checker *Checker) statements(statements []*parser.AST_Statement) {
+	for _, statement := range statements {
+		checker.statement(statement)
+	}
+}
+
+func (checker *Checker) statement(statement *parser.AST_Statement) {
+	switch statement.SType {
+	case parser.ST_STATEMENT_ARRAY:
+		checker.statements(statement.Statements)
+	case parser.ST_STATEMENT:
+		checker.statement(statement.Statement)
+	case parser.ST_EXPRESSION:
+		checker.expression(statement.Expression)
+	case parser.ST_FUNCTION:
+		checker.function(statement.Function)
+	case parser.ST_DECLARATION:
+		declaration := statement.Declaration
+		t := checker.declarationType(declaration)
+
+		if declaration.Value == nil {
+			return
+		}
+
+		if value := checker.value(declaration.Value); !checker.assign(t, value, declaration.Value) {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_TYPE_MISMATCH,
+				declaration.Value.Span,
+				"cannot use %s as %s in the declaration of %s", value, t, declaration.Name))
+		}
+	case parser.ST_STRUCT:
+		checker.fieldTypes(statement.Struct)
+	case parser.ST_INTERFACE:
+		checker.interfaceDeclaration(statement.Struct)
+	case parser.ST_ASSIGNMENT:
+		checker.assignment(statement.Assignment)
+	case parser.ST_IF:
+		for branch := statement.If; branch != nil; branch = branch.Else {
+			if branch.Condition != nil {
+				checker.condition(branch.Condition)
+			}
+
+			checker.statements(branch.Statements)
+		}
+	case parser.ST_WHILE:
+		checker.condition(statement.Loop.Condition)
+		checker.statements(statement.Loop.Statements)
+	case parser.ST_FOR:
+		checker.iterate(statement.Loop)
+		checker.statements(statement.Loop.Statements)
+	case parser.ST_RETURN:
+		value := checker.expression(statement.Expression)
+
+		if checker.frame == nil {
+			return
+		}
+
+		checker.frame.returned = true
+		checker.result(statement.Expression, value)
+	}
+}
+
+func (checker *Checker) assignment(assignment *parser.AST_Assignment) {
+	target := checker.expression(assignment.Target)
+
+	var value *term
+
+	if _, ok := parser.CompoundOperators[assignment.Operator]; ok {
+		// x += v is checked as x + v
+		value = checker.arithmetic(&parser.AST_Expression{
+			EType:    parser.ET_BINARY,
+			Lhs:      assignment.Target,
+			Operator: assignment.Operator,
+			Rhs:      assignment.Value,
+			Span:     diagnostic.Span{Start: assignment.Target.Span.Start, End: assignment.Value.Span.End},
+		})
+	} else {
+		value = checker.value(assignment.Value)
+	}
+
+	if !checker.assign(target, value, assignment.Value) {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_TYPE_MISMATCH,
+			assignment.Value.Span,
+			"cannot assign %s to %s", value, target))
+	}
+}
+
+// iterate types the variable of a for loop. Ints are counted up to, so
+// the variable has the type of the int, strings yield their characters
+// as ints.
+func (checker *Checker) iterate(loop *parser.AST_Loop) {
+	collection := find(checker.value(loop.Collection))
+	element := checker.declarationType(loop.Variable)
+
+	switch {
+	case collection.isVariable():
+		collection.numeric = true
+		unify(element, collection)
+	case collection.Type == parser.TYPE_NUMBER:
+		unify(element, collection)
+	case collection.Type == parser.TYPE_STRING:
+		unify(element, primitive(parser.TYPE_NUMBER, ""))
+	default:
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_INVALID_OPERAND,
+			loop.Collection.Span,
+			"cannot iterate over %s", collection))
+	}
+}
+
+// result unifies a returned value with the result of the current function
+func (checker *Checker) result(expression *parser.AST_Expression, value *term) {
+	function := checker.functionType(checker.frame.function)
+
+	if !checker.assign(function.Result, value, expression) {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_TYPE_MISMATCH,
+			expression.Span,
+			"cannot return %s from a function returning %s", value, function.Result))
+	}
+}
+
+func (checker *Checker) function(function *parser.AST_Function) *term {
+	t := checker.functionType(function)
+
+	if checker.checked[function] {
+		return t
+	}
+
+	checker.checked[function] = true
+
+	outer := checker.frame
+	checker.frame = &frame{function: function}
+	defer func() { checker.frame = outer }()
+
+	if body := function.ExpressionBody(); body != nil {
+		checker.result(body, checker.expression(body))
+		return t
+	}
+
+	checker.statement(function.Statement)
+
+	if !checker.frame.returned && !unify(t.Result, primitive(parser.TYPE_VOID, "")) {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_TYPE_MISMATCH,
+			function.Span,
+			"%s does not return a value, expected %s", function.Name, t.Result))
+	}
+
+	return t
+}
+
+// value checks an expression whose value is used, which rules out void
+func (checker *Checker) value(expression *parser.AST_Expression) *term {
+	t := checker.expression(expression)
+
+	if find(t).Type == parser.TYPE_VOID {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_INVALID_OPERAND,
+			expression.Span,
+			"void value used as a value"))
+
+		return variable()
+	}
+
+	return t
+}
+
+// condition checks an operand of a logical operator or the condition of an
+// if, which may be a bool or a number
+func (checker *Checker) condition(expression *parser.AST_Expression) {
+	t := find(checker.value(expression))
+
+	if !t.isVariable() && !t.isNumeric() && t.Type != parser.TYPE_BOOL {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_INVALID_OPERAND,
+			expression.Span,
+			"%s cannot be used as a condition", t))
+	}
+}
+
+// numeric checks an operand of an arithmetic operator, it returns false for other types
+func (checker *Checker) numeric(expression *parser.AST_Expression, operand *term, operator lexer.LexemeType) bool {
+	t := find(operand)
+
+	if t.isVariable() {
+		t.numeric = true
+		return true
+	}
+
+	if t.isNumeric() {
+		return true
+	}
+
+	checker.report(diagnostic.Error(
+		diagnostic.CODE_INVALID_OPERAND,
+		expression.Span,
+		"operator %s is not defined for %s", lexer.LexemeTypeLabels[operator], t))
+
+	return false
+}
+
+func (checker *Checker) expression(expression *parser.AST_Expression) *term {
+	t := checker.infer(expression)
+	checker.expressions[expression] = t
+
+	return t
+}
+
+func (checker *Checker) infer(expression *parser.AST_Expression) *term {
+	switch expression.EType {
+	case parser.ET_VALUE:
+		if expression.Value.Struct != nil {
+			return checker.structLiteral(expression)
+		}
+
+		return checker.literal(expression.Value)
+	case parser.ET_IDENTIFIER:
+		if symbol, ok := checker.bindings[expression]; ok && symbol.Kind != semantic.SK_BUILTIN {
+			return checker.symbolType(symbol)
+		}
+	case parser.ET_GROUP:
+		return checker.expression(expression.Lhs)
+	case parser.ET_EXPRESSION_ARRAY:
+		checker.expression(expression.Lhs)
+		return checker.expression(expression.Rhs)
+	case parser.ET_UNARY:
+		if expression.Operator == lexer.LT_BANG {
+			checker.condition(expression.Rhs)
+			return primitive(parser.TYPE_BOOL, "")
+		}
+
+		operand := checker.value(expression.Rhs)
+
+		if checker.numeric(expression.Rhs, operand, expression.Operator) {
+			return operand
+		}
+	case parser.ET_BINARY:
+		return checker.binary(expression)
+	case parser.ET_FUNCTION_CALL:
+		return checker.call(expression)
+	case parser.ET_MEMBER_ACCESS:
+		return checker.memberAccess(expression)
+	}
+
+	return variable()
+}
+
+func (checker *Checker) literal(value *parser.AST_Value) *term {
+	switch value.Type {
+	case parser.TYPE_FUNCTION:
+		return checker.function(value.Function)
+	case parser.TYPE_NUMBER, parser.TYPE_FLOAT:
+		return primitive(value.Type, value.Suffix)
+	case parser.TYPE_STRING, parser.TYPE_BOOL:
+		return primitive(value.Type, "")
+	}
+
+	return variable()
+}
+
+func (checker *Checker) binary(expression *parser.AST_Expression) *term {
+	switch expression.Operator {
+	case lexer.LT_PLUS, lexer.LT_MINUS, lexer.LT_MULTIPLY, lexer.LT_DIVIDE, lexer.LT_MODULO:
+		return checker.arithmetic(expression)
+	case lexer.LT_LCHEVRON, lexer.LT_RCHEVRON, lexer.LT_LEQ, lexer.LT_GEQ:
+		checker.arithmetic(expression)
+	case lexer.LT_EQ, lexer.LT_NEQ:
+		lhs := checker.value(expression.Lhs)
+		rhs := checker.value(expression.Rhs)
+
+		if t := incomparable(lhs, rhs); t != nil {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_INVALID_OPERAND,
+				expression.Span,
+				"operator %s is not defined for %s", lexer.LexemeTypeLabels[expression.Operator], t))
+		} else if find(lhs).isNumeric() && find(rhs).isNumeric() {
+			// Numbers of any kind compare with each other
+		} else if !unify(lhs, rhs) {
+			checker.mismatch(expression.Span, lhs, rhs)
+		}
+	default:
+		// Logical operators
+		checker.condition(expression.Lhs)
+		checker.condition(expression.Rhs)
+	}
+
+	return primitive(parser.TYPE_BOOL, "")
+}
+
+// incomparable returns the first operand == and != are not defined for, nil when there is none
+func incomparable(operands ...*term) *term {
+	for _, operand := range operands {
+		if t := find(operand); t.Type == parser.TYPE_STRING || t.Type == parser.TYPE_STRUCT || t.Type == parser.TYPE_INTERFACE {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// arithmetic checks a binary operator over numbers, mixing an integer and a
+// float gives a float
+func (checker *Checker) arithmetic(expression *parser.AST_Expression) *term {
+	lhs := checker.value(expression.Lhs)
+	rhs := checker.value(expression.Rhs)
+
+	valid := checker.numeric(expression.Lhs, lhs, expression.Operator)
+	valid = checker.numeric(expression.Rhs, rhs, expression.Operator) && valid
+
+	if !valid {
+		return variable()
+	}
+
+	lhs, rhs = find(lhs), find(rhs)
+
+	if lhs.isVariable() || rhs.isVariable() {
+		result := variable()
+		result.numeric = true
+
+		checker.pending = append(checker.pending, operation{expression, lhs, rhs, result})
+
+		return result
+	}
+
+	return checker.widen(expression, lhs, rhs)
+}
+
+// widen returns the type of arithmetic over two numbers
+func (checker *Checker) widen(expression *parser.AST_Expression, lhs *term, rhs *term) *term {
+	if expression.Operator == lexer.LT_MODULO || expression.Operator == lexer.LT_MODULO_EQUALS {
+		// C has no % for floats
+		for _, operand := range []*term{lhs, rhs} {
+			if operand.Type == parser.TYPE_FLOAT {
+				checker.report(diagnostic.Error(
+					diagnostic.CODE_INVALID_OPERAND,
+					expression.Span,
+					"operator %s is not defined for %s", lexer.LexemeTypeLabels[expression.Operator], operand))
+
+				break
+			}
+		}
+	}
+
+	if lhs.Type != rhs.Type {
+		if lhs.Type == parser.TYPE_FLOAT {
+			return lhs
+		}
+
+		return rhs
+	}
+
+	if !unify(lhs, rhs) {
+		checker.mismatch(expression.Span, lhs, rhs)
+	}
+
+	// The width of a suffixed operand wins over an unsuffixed literal
+	if lhs.Name == "" {
+		return rhs
+	}
+
+	return lhs
+}
+
+func (checker *Checker) arguments(params []*parser.AST_Expression) []*term {
+	result := make([]*term, 0, len(params))
+
+	for _, param := range params {
+		result = append(result, checker.value(param))
+	}
+
+	return result
+}
+
+func (checker *Checker) call(expression *parser.AST_Expression) *term {
+	call := expression.FunctionCall
+	arguments := checker.arguments(call.Params)
+
+	symbol, ok := checker.bindings[expression]
+
+	if !ok {
+		return variable()
+	}
+
+	if symbol.Kind == semantic.SK_BUILTIN {
+		// printf, puts and putchar take anything and return an int
+		return primitive(parser.TYPE_NUMBER, "")
+	}
+
+	return checker.apply(expression, call.Name, checker.symbolType(symbol), arguments)
+}
+
+// apply checks calling a function of type callee with the arguments of the call expression
+func (checker *Checker) apply(expression *parser.AST_Expression, name string, callee *term, arguments []*term) *term {
+	call := expression.FunctionCall
+	callee = find(callee)
+
+	if callee.isVariable() {
+		// A parameter that is called is a function
+		function := &term{Type: parser.TYPE_FUNCTION, Params: arguments, Result: variable()}
+
+		if unify(callee, function) {
+			return function.Result
+		}
+	}
+
+	if callee.Type != parser.TYPE_FUNCTION {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_NOT_CALLABLE,
+			expression.Span,
+			"%s is not a function, it has type %s", name, callee))
+
+		return variable()
+	}
+
+	if len(arguments) != len(callee.Params) {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_ARGUMENT_COUNT,
+			expression.Span,
+			"%s takes %d arguments, %d given", name, len(callee.Params), len(arguments)))
+
+		return callee.Result
+	}
+
+	for index, argument := range arguments {
+		if !checker.assign(callee.Params[index], argument, call.Params[index]) {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_TYPE_MISMATCH,
+				call.Params[index].Span,
+				"cannot use %s as %s in argument %d of %s", argument, callee.Params[index], index+1, name))
+		}
+	}
+
+	return callee.Result
+}
+
+// structLiteral checks that the literal sets every field of its struct with
+// a value of the type of the field
+func (checker *Checker) structLiteral(expression *parser.AST_Expression) *term {
+	literal := expression.Value.Struct
+	values := make([]*term, 0, len(literal.Fields))
+
+	for _, field := range literal.Fields {
+		values = append(values, checker.value(field.Value))
+	}
+
+	symbol, ok := checker.bindings[expression]
+
+	if !ok {
+		return variable()
+	}
+
+	if symbol.Kind != semantic.SK_STRUCT {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_UNKNOWN_TYPE,
+			expression.Span,
+			"%s is not a struct", literal.Name))
+
+		return variable()
+	}
+
+	fields := checker.fieldTypes(symbol.Struct)
+	set := make(map[string]bool)
+
+	for index, field := range literal.Fields {
+		set[field.Name] = true
+		t, ok := fields[field.Name]
+
+		if !ok {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_UNKNOWN_FIELD,
+				field.Span,
+				"%s has no field %s", literal.Name, field.Name))
+
+			continue
+		}
+
+		if !checker.assign(t, values[index], field.Value) {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_TYPE_MISMATCH,
+				field.Value.Span,
+				"cannot use %s as %s in field %s of %s", values[index], t, field.Name, literal.Name))
+		}
+	}
+
+	missing := make([]string, 0)
+
+	for _, field := range symbol.Struct.Fields {
+		if !set[field.Name] {
+			missing = append(missing, field.Name)
+		}
+	}
+
+	if len(missing) > 0 {
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_MISSING_FIELD,
+			expression.Span,
+			"%s literal is missing %s", literal.Name, strings.Join(missing, ", ")))
+	}
+
+	return primitive(parser.TYPE_STRUCT, symbol.Struct.Name)
+}
+
+// memberAccess types a.b.c, each member is a field of the struct before it
+func (checker *Checker) memberAccess(expression *parser.AST_Expression) *term {
+	t := checker.root(expression)
+
+	for member := expression.Rhs; member != nil; member = member.Rhs {
+		t = checker.member(t, member)
+	}
+
+	return t
+}
+
+// root types the value a member access starts from, see parser.memberAccess for the shape of the tree
+func (checker *Checker) root(expression *parser.AST_Expression) *term {
+	switch {
+	case expression.FunctionCall != nil:
+		return checker.call(expression)
+	case expression.Value != nil && expression.Value.Struct != nil:
+		return checker.structLiteral(expression)
+	case expression.Value != nil:
+		return checker.literal(expression.Value)
+	case expression.Lhs != nil:
+		return checker.expression(expression.Lhs)
+	}
+
+	if symbol, ok := checker.bindings[expression]; ok && symbol.Kind != semantic.SK_BUILTIN {
+		return checker.symbolType(symbol)
+	}
+
+	return variable()
+}
+
+// member types the field named by a link of a member access, which may be a
+// call of a function field or of an interface member. Members of values
+// whose type is not known yet are left untyped.
+func (checker *Checker) member(owner *term, link *parser.AST_Expression) *term {
+	member := link.Lhs
+	name := member.Identifier
+	var arguments []*term
+
+	if member.FunctionCall != nil {
+		name = member.FunctionCall.Name
+		arguments = checker.arguments(member.FunctionCall.Params)
+	}
+
+	owner = find(owner)
+	definition, ok := checker.structs[owner.Name]
+
+	if owner.Type == parser.TYPE_INTERFACE {
+		definition, ok = checker.interfaces[owner.Name]
+	}
+
+	switch {
+	case owner.isVariable():
+	case owner.Type != parser.TYPE_STRUCT && owner.Type != parser.TYPE_INTERFACE || !ok:
+		checker.report(diagnostic.Error(
+			diagnostic.CODE_INVALID_OPERAND,
+			member.Span,
+			"%s has no fields, cannot access %s", owner, name))
+	default:
+		checker.expressions[link] = owner
+		field, ok := checker.fieldTypes(definition)[name]
+
+		if !ok {
+			checker.report(diagnostic.Error(
+				diagnostic.CODE_UNKNOWN_FIELD,
+				member.Span,
+				"%s has no field %s", owner, name))
+
+			break
+		}
+
+		if member.FunctionCall != nil {
+			return checker.apply(member, owner.Name+"."+name, field, arguments)
+		}
+
+		return field
+	}
+
+	t := variable()
+	checker.members[t] = true
+
+	return t
+}
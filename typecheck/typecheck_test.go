@@ -0,0 +1,138 @@
+package typecheck
+
+import (
+	"testing"
+
+	"github.com/milansav/Castle/diagnostic"
+	"github.com/milansav/Castle/lexer"
+	"github.com/milansav/Castle/parser"
+	"github.com/milansav/Castle/semantic"
+)
+
+func check(t *testing.T, input string) (*parser.AST_Program, []diagnostic.Diagnostic) {
+	mainLexer := lexer.Create(input)
+	mainLexer.Start()
+
+	mainParser := parser.Create(mainLexer)
+	program, diagnostics := mainParser.Start()
+
+	if len(diagnostics) != 0 {
+		t.Errorf("parser.Start reported %d diagnostics for %q", len(diagnostics), input)
+	}
+
+	analyzer := semantic.Create(program)
+
+	if diagnostics := analyzer.Start(); len(diagnostics) != 0 {
+		t.Errorf("Analyzer.Start reported %d diagnostics for %q", len(diagnostics), input)
+	}
+
+	checker := Create(program, analyzer.Bindings)
+
+	return program, checker.Start()
+}
+
+func TestCheckerDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"const a = 1;\nconst b = a * 2.5 - 1;", []string{}},
+		{"const f = (a, b) => a + b;\nconst c = f(1, 2.5) < 3;", []string{}},
+		{"const a = \"x\" + 1;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const a = -true;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const a = \"x\" == \"y\";", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const a = true == 1;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const a = 1u8 + 1i32;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const f = (x) => x * 2;\nf(\"s\");", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const f = (x, y) => x;\nf(1);", []string{diagnostic.CODE_ARGUMENT_COUNT}},
+		{"const a = 1;\na(2);", []string{diagnostic.CODE_NOT_CALLABLE}},
+		{"const f = (x) => {\n if (x) {\n return 1;\n }\n return \"s\";\n};", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const f = () => {\n printf(\"x\");\n};\nconst a = f() + 1;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"if (\"s\") {\n printf(\"x\");\n}", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const f = (g) => g(1) + 1;\nconst h = (x) => x;\nf(h);", []string{}},
+		{"const x: float = 1;\nconst y: u8 = x;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const f = (a: string): int => a;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"const f = (): int => {\n printf(\"x\");\n};", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"val p: Point;", []string{diagnostic.CODE_UNKNOWN_TYPE}},
+		{"val a = 1;\na += 2;\na = a * 3;", []string{}},
+		{"val a = 1.5;\na += 2;", []string{}},
+		{"val a = 1;\na += 2.5;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"val s = \"x\";\ns += 1;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"val s = \"x\";\ns = true;", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"val i = 7 % 3;\ni %= 2;", []string{}},
+		{"val f = 1.5;\nf %= 2;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const f = (a) => a % 1.5;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const apply = (f: (int) => int) => f(1);\nconst g = (s: string) => 1;\napply(g);", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"val a = 0;\nwhile (a < 10) {\n a += 1;\n}", []string{}},
+		{"while (\"s\") {\n break;\n}", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const f = (n) => {\n val t = 0;\n for (i of n) {\n  t += i;\n }\n return t;\n};\nf(3);", []string{}},
+		{"for (c of \"abc\") {\n const d: int = c + 1;\n}", []string{}},
+		{"for (x of 2.5) {\n printf(\"%f\", x);\n}", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"for (x of 3u8) {\n const y: string = x;\n}", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"struct P {\n x: int,\n y: float,\n}\nval p = P { x: 1, y: 2 };\np.y += 0.5;\nconst f = (a: P): float => a.x + a.y;", []string{}},
+		{"struct P {\n x: int,\n}\nconst p = P { x: \"s\" };", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"struct P {\n x: int,\n}\nconst p = P {};", []string{diagnostic.CODE_MISSING_FIELD}},
+		{"struct P {\n x: int,\n}\nconst p = P { x: 1, z: 2 };\nconst z = p.z;", []string{diagnostic.CODE_UNKNOWN_FIELD, diagnostic.CODE_UNKNOWN_FIELD}},
+		{"struct P {\n q: Q,\n}", []string{diagnostic.CODE_UNKNOWN_TYPE}},
+		{"const a = 1;\nconst b = a.x;", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"const a = 1;\nconst b = a { x: 1 };", []string{diagnostic.CODE_UNKNOWN_TYPE}},
+		{"struct P {\n f: (int) => int,\n}\nconst g = (x) => x;\nconst p = P { f: g };\nconst r: string = p.f(1);", []string{diagnostic.CODE_TYPE_MISMATCH}},
+		{"struct P {\n x: int,\n}\nconst a = P { x: 1 } == P { x: 1 };", []string{diagnostic.CODE_INVALID_OPERAND}},
+		{"interface S {\n f: () => int,\n}\nstruct P {\n x: int,\n f: () => int,\n}\nconst g = () => 1;\nconst h = (s: S): int => s.f() + 1;\nh(P { x: 1, f: g });", []string{}},
+		{"interface S {\n f: () => int,\n}\nstruct P {\n x: int,\n}\nconst s: S = P { x: 1 };", []string{diagnostic.CODE_NOT_SATISFIED}},
+		{"interface S {\n f: () => int,\n}\nstruct P {\n f: () => string,\n}\nconst g = () => \"s\";\nconst s: S = P { f: g };", []string{diagnostic.CODE_NOT_SATISFIED}},
+		{"interface S {\n x: int,\n}", []string{diagnostic.CODE_NOT_CALLABLE}},
+		{"interface S {\n f: () => int,\n}\nconst h = (s: S): string => s.f();", []string{diagnostic.CODE_TYPE_MISMATCH}},
+	}
+
+	for _, test := range tests {
+		_, diagnostics := check(t, test.input)
+
+		if len(diagnostics) != len(test.expected) {
+			t.Errorf("Checker.Start reported %v for %q, expected %v", diagnostics, test.input, test.expected)
+			continue
+		}
+
+		for index := range diagnostics {
+			if diagnostics[index].Code != test.expected[index] {
+				t.Errorf("Checker.Start reported %v for %q, expected %v", diagnostics, test.input, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestCheckerAnnotations(t *testing.T) {
+	input := "const add = (a, b) => a + b;\nconst x = add(1, 2.5);\nconst s = \"s\";\nconst n = 255u8;\nconst log = (m) => {\n printf(m);\n};\nlog(s);"
+
+	program, diagnostics := check(t, input)
+
+	if len(diagnostics) != 0 {
+		t.Fatalf("Checker.Start reported %v", diagnostics)
+	}
+
+	expected := map[string]string{
+		"add": "(int, float) => float",
+		"x":   "float",
+		"s":   "string",
+		"n":   "u8",
+		"log": "(string) => void",
+	}
+
+	for _, statement := range program.Statements {
+		if statement.SType != parser.ST_DECLARATION {
+			continue
+		}
+
+		declaration := statement.Declaration
+		result := declaration.Value.Type.String()
+
+		if function := declaration.Value.Value; function != nil && function.Function != nil {
+			result = function.Function.Type.String()
+		}
+
+		if result != expected[declaration.Name] {
+			t.Errorf("Checker.Start typed %s as %s, expected %s", declaration.Name, result, expected[declaration.Name])
+		}
+	}
+}
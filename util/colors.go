@@ -1,5 +1,10 @@
 package util
 
+import (
+	"io"
+	"os"
+)
+
 var Reset = "\033[0m"
 var Red = "\033[31m"
 var Green = "\033[32m"
@@ -9,3 +14,21 @@ var Purple = "\033[35m"
 var Cyan = "\033[36m"
 var Gray = "\033[37m"
 var White = "\033[97m"
+
+// ColorsEnabled reports whether escape sequences should be written to w. They
+// are disabled when NO_COLOR is set (https://no-color.org) or w is not a terminal.
+func ColorsEnabled(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	file, ok := w.(*os.File)
+
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -50,3 +50,8 @@ var keywords = MakeSet(
 		"asm",
 		"fortran",
 	})
+
+// IsReservedC reports whether name is a C keyword and cannot be used as an identifier in generated code
+func IsReservedC(name string) bool {
+	return keywords.Has(name)
+}